@@ -0,0 +1,143 @@
+package avltree
+
+import (
+	"sync"
+
+	"github.com/zergon321/mempool"
+)
+
+// NodeAllocator provides the tree with
+// nodes and takes them back once they're
+// removed from the tree.
+type NodeAllocator[N any] interface {
+	// Get returns an empty node.
+	Get() N
+	// Put takes the node back so it
+	// can be reused. The trees ignore
+	// the error since the node is
+	// already out of the tree and
+	// can be left to the GC.
+	Put(node N) error
+}
+
+// erasablePointer is a pointer to
+// a node type which can be erased
+// before it's reused.
+type erasablePointer[T any] interface {
+	*T
+	mempool.Erasable
+}
+
+// MempoolAllocator adapts the memory
+// pool to the NodeAllocator interface.
+func MempoolAllocator[N mempool.Erasable](pool *mempool.Pool[N]) NodeAllocator[N] {
+	if pool == nil {
+		return nil
+	}
+
+	return pool
+}
+
+/*===============================================================*/
+
+// SyncPoolAllocator is a NodeAllocator
+// backed by sync.Pool. It's safe for
+// concurrent use and lets the GC
+// drop the unused nodes.
+type SyncPoolAllocator[T any, PT erasablePointer[T]] struct {
+	pool sync.Pool
+}
+
+// Get returns an empty node.
+func (alloc *SyncPoolAllocator[T, PT]) Get() PT {
+	return alloc.pool.Get().(PT)
+}
+
+// Put erases the node and
+// puts it back to the pool.
+func (alloc *SyncPoolAllocator[T, PT]) Put(node PT) error {
+	err := node.Erase()
+
+	if err != nil {
+		return err
+	}
+
+	alloc.pool.Put(node)
+
+	return nil
+}
+
+// NewSyncPoolAllocator returns a new
+// allocator backed by sync.Pool.
+func NewSyncPoolAllocator[T any, PT erasablePointer[T]]() *SyncPoolAllocator[T, PT] {
+	alloc := &SyncPoolAllocator[T, PT]{}
+	alloc.pool.New = func() any {
+		return PT(new(T))
+	}
+
+	return alloc
+}
+
+/*===============================================================*/
+
+// SlabAllocator is a NodeAllocator which
+// carves the nodes from large chunks of
+// memory to reduce the number of allocations
+// and the GC pressure. A chunk is only freed
+// when none of its nodes is referenced.
+// Unlike SyncPoolAllocator, it's not safe
+// for concurrent use, so it must not be
+// shared by trees written concurrently.
+type SlabAllocator[T any, PT erasablePointer[T]] struct {
+	chunkSize int
+	chunk     []T
+	free      []PT
+}
+
+// Get returns an empty node.
+func (alloc *SlabAllocator[T, PT]) Get() PT {
+	if length := len(alloc.free); length > 0 {
+		node := alloc.free[length-1]
+		alloc.free[length-1] = nil
+		alloc.free = alloc.free[:length-1]
+
+		return node
+	}
+
+	if len(alloc.chunk) <= 0 {
+		alloc.chunk = make([]T, alloc.chunkSize)
+	}
+
+	node := PT(&alloc.chunk[0])
+	alloc.chunk = alloc.chunk[1:]
+
+	return node
+}
+
+// Put erases the node and
+// keeps it for reuse.
+func (alloc *SlabAllocator[T, PT]) Put(node PT) error {
+	err := node.Erase()
+
+	if err != nil {
+		return err
+	}
+
+	alloc.free = append(alloc.free, node)
+
+	return nil
+}
+
+// NewSlabAllocator returns a new allocator
+// carving chunkSize nodes at once.
+func NewSlabAllocator[T any, PT erasablePointer[T]](chunkSize int) (*SlabAllocator[T, PT], error) {
+	if chunkSize <= 0 {
+		return nil, &ErrorNonPositiveChunkSize{
+			chunkSize: chunkSize,
+		}
+	}
+
+	return &SlabAllocator[T, PT]{
+		chunkSize: chunkSize,
+	}, nil
+}
@@ -0,0 +1,63 @@
+package avltree_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zergon321/go-avltree"
+)
+
+func TestAllocators(t *testing.T) {
+	slab, err := avltree.NewSlabAllocator[avltree.AVLNode[int, int]](16)
+	assert.Nil(t, err)
+
+	allocators := map[string]avltree.NodeAllocator[*avltree.AVLNode[int, int]]{
+		"sync.Pool": avltree.NewSyncPoolAllocator[avltree.AVLNode[int, int]](),
+		"slab":      slab,
+	}
+
+	for name, alloc := range allocators {
+		t.Run(name, func(t *testing.T) {
+			tree, err := avltree.NewAVLTree(avltree.AVLTreeOptionWithAllocator[int, int](alloc))
+			assert.Nil(t, err)
+
+			data := make([]byte, 3000)
+			rand.Read(data)
+			runOperations(t, avlSubject{tree: tree}, data)
+		})
+	}
+}
+
+func TestSlabAllocatorReuse(t *testing.T) {
+	alloc, err := avltree.NewSlabAllocator[avltree.UnrestrictedAVLNode[IntKey, int]](4)
+	assert.Nil(t, err)
+
+	tree, err := avltree.NewUnrestrictedAVLTree(
+		avltree.UnrestrictedAVLTreeOptionWithAllocator[IntKey, int](alloc))
+	assert.Nil(t, err)
+
+	tree.Add(1, 1)
+	node := tree.Search(1)
+	tree.Remove(1)
+	tree.Add(2, 2)
+
+	assert.Same(t, node, tree.Search(2))
+}
+
+func TestSlabAllocatorChunkSize(t *testing.T) {
+	_, err := avltree.NewSlabAllocator[avltree.AVLNode[int, int]](0)
+
+	var chunkErr *avltree.ErrorNonPositiveChunkSize
+	assert.ErrorAs(t, err, &chunkErr)
+}
+
+func BenchmarkAVLInsertSlabAllocator(b *testing.B) {
+	alloc, _ := avltree.NewSlabAllocator[avltree.AVLNode[int, int]](1024)
+	tree, _ := avltree.NewAVLTree(avltree.AVLTreeOptionWithAllocator[int, int](alloc))
+
+	for i := 0; i < b.N; i++ {
+		value := rand.Int()
+		tree.Add(value, value)
+	}
+}
@@ -0,0 +1,480 @@
+package avltree
+
+import (
+	"fmt"
+	"math"
+
+	"golang.org/x/exp/constraints"
+)
+
+// nilIndex marks the absence of a node
+// in the arena tree. The first element
+// of the arena is reserved for it so
+// the zero value of the tree is usable.
+const nilIndex int32 = 0
+
+// ArenaAVLTree is an AVL tree storing its nodes in a contiguous
+// slice and linking them by int32 indices instead of pointers.
+// If TKey and TValue contain no pointers, the GC never scans
+// the nodes of the tree. Removed nodes are kept in a free list
+// and reused by the subsequent insertions.
+type ArenaAVLTree[TKey constraints.Ordered, TValue any] struct {
+	nodes []ArenaAVLNode[TKey, TValue]
+	root  int32
+	// free is the head of the free
+	// list linked through the left
+	// indices of the removed nodes.
+	free int32
+	// path is the scratch stack
+	// of node indices used by writes.
+	path []int32
+	// size is the number
+	// of nodes in the tree.
+	size int
+}
+
+// ArenaAVLNode is a node of the arena tree.
+// Pointers to the nodes are only valid until
+// the next write to the tree because the
+// arena may be reallocated.
+type ArenaAVLNode[TKey constraints.Ordered, TValue any] struct {
+	key   TKey
+	Value TValue
+
+	// height counts nodes (not edges)
+	height int32
+	left   int32
+	right  int32
+}
+
+// Key returns the key of the arena tree node.
+func (node *ArenaAVLNode[TKey, TValue]) Key() TKey {
+	return node.key
+}
+
+func (t *ArenaAVLTree[TKey, TValue]) Add(key TKey, value TValue) {
+	node := t.search(key)
+
+	if node != nilIndex {
+		// if same key exists update value
+		t.nodes[node].Value = value
+		return
+	}
+
+	t.insert(key, value)
+}
+
+func (t *ArenaAVLTree[TKey, TValue]) AddOrUpdate(
+	key TKey, value TValue,
+	upd func(oldValue TValue) (TValue, error),
+) error {
+	node := t.search(key)
+
+	if node == nilIndex {
+		t.insert(key, value)
+		return nil
+	}
+
+	value, err := upd(t.nodes[node].Value)
+
+	if err != nil {
+		return err
+	}
+
+	t.nodes[node].Value = value
+
+	return nil
+}
+
+func (t *ArenaAVLTree[TKey, TValue]) Remove(key TKey) {
+	t.remove(key)
+}
+
+// Update moves the entry of the old key to the new key
+// and sets the new value. The slot of the old key is reused
+// for the new key. If the new key already exists, its value
+// is overwritten. Returns false and leaves the tree unchanged
+// if there's no old key in the tree.
+func (t *ArenaAVLTree[TKey, TValue]) Update(oldKey TKey, newKey TKey, newValue TValue) bool {
+	existed, _ := t.update(oldKey, newKey, newValue, false)
+	return existed
+}
+
+// UpdateStrict works like Update but fails with
+// ErrorKeyExists leaving the tree unchanged
+// if the new key already exists.
+func (t *ArenaAVLTree[TKey, TValue]) UpdateStrict(oldKey TKey, newKey TKey, newValue TValue) (bool, error) {
+	return t.update(oldKey, newKey, newValue, true)
+}
+
+// Search returns the node with the key or nil
+// if there's no such key in the tree. The node
+// is only valid until the next write to the tree.
+func (t *ArenaAVLTree[TKey, TValue]) Search(key TKey) *ArenaAVLNode[TKey, TValue] {
+	node := t.search(key)
+
+	if node == nilIndex {
+		return nil
+	}
+
+	return &t.nodes[node]
+}
+
+// VisitInOrder visits the nodes in the ascending
+// order of keys. The tree must not be modified
+// during the traversal.
+func (t *ArenaAVLTree[TKey, TValue]) VisitInOrder(visit func(node *ArenaAVLNode[TKey, TValue]) error) error {
+	var stack [maxHeight]int32
+	top := 0
+	node := t.root
+
+	for node != nilIndex || top > 0 {
+		for node != nilIndex {
+			stack[top] = node
+			top++
+			node = t.nodes[node].left
+		}
+
+		top--
+		node = stack[top]
+
+		err := visit(&t.nodes[node])
+
+		if err != nil {
+			return err
+		}
+
+		node = t.nodes[node].right
+	}
+
+	return nil
+}
+
+// Clear removes all the nodes from the
+// tree keeping the arena memory for reuse.
+func (t *ArenaAVLTree[TKey, TValue]) Clear() {
+	var zeroNode ArenaAVLNode[TKey, TValue]
+
+	for i := range t.nodes {
+		t.nodes[i] = zeroNode
+	}
+
+	t.nodes = t.nodes[:0]
+	t.root = nilIndex
+	t.free = nilIndex
+	t.size = 0
+}
+
+// Len returns the number
+// of entries in the tree.
+func (t *ArenaAVLTree[TKey, TValue]) Len() int {
+	return t.size
+}
+
+// Moves the entry of the old key to the new key
+func (t *ArenaAVLTree[TKey, TValue]) update(oldKey TKey, newKey TKey, newValue TValue, strict bool) (bool, error) {
+	node := t.search(oldKey)
+
+	if node == nilIndex {
+		return false, nil
+	}
+
+	if newKey == oldKey {
+		t.nodes[node].Value = newValue
+		return true, nil
+	}
+
+	if strict && t.search(newKey) != nilIndex {
+		return true, &ErrorKeyExists{
+			key: newKey,
+		}
+	}
+
+	// the removed node is put to the
+	// free list and taken by Add again
+	t.remove(oldKey)
+	t.Add(newKey, newValue)
+
+	return true, nil
+}
+
+// Searches for a node index
+func (t *ArenaAVLTree[TKey, TValue]) search(key TKey) int32 {
+	node := t.root
+
+	for node != nilIndex {
+		n := &t.nodes[node]
+
+		if key < n.key {
+			node = n.left
+		} else if key > n.key {
+			node = n.right
+		} else {
+			return node
+		}
+	}
+
+	return nilIndex
+}
+
+// Takes a node from the free list
+// or appends a new one to the arena
+func (t *ArenaAVLTree[TKey, TValue]) allocate(key TKey, value TValue) int32 {
+	var node int32
+
+	if t.free != nilIndex {
+		node = t.free
+		t.free = t.nodes[node].left
+	} else {
+		if len(t.nodes) == 0 {
+			// reserve the nil index
+			t.nodes = append(t.nodes, ArenaAVLNode[TKey, TValue]{})
+		}
+
+		if len(t.nodes) > math.MaxInt32 {
+			panic(fmt.Sprintf("arena tree can't hold more than %d nodes", math.MaxInt32))
+		}
+
+		node = int32(len(t.nodes))
+		t.nodes = append(t.nodes, ArenaAVLNode[TKey, TValue]{})
+	}
+
+	t.nodes[node] = ArenaAVLNode[TKey, TValue]{
+		key:    key,
+		Value:  value,
+		height: 1,
+	}
+
+	return node
+}
+
+// Puts the node to the free list
+func (t *ArenaAVLTree[TKey, TValue]) release(node int32) {
+	t.nodes[node] = ArenaAVLNode[TKey, TValue]{
+		left: t.free,
+	}
+	t.free = node
+}
+
+// Returns the empty scratch path stack of the tree
+func (t *ArenaAVLTree[TKey, TValue]) pathStack() []int32 {
+	if t.path == nil {
+		t.path = make([]int32, 0, maxHeight)
+	}
+
+	return t.path[:0]
+}
+
+// Inserts a key which is absent from the tree
+// and then rebalances the path bottom-up
+func (t *ArenaAVLTree[TKey, TValue]) insert(key TKey, value TValue) {
+	// allocate first because
+	// the arena may be reallocated
+	node := t.allocate(key, value)
+	path := t.pathStack()
+	parent := t.root
+
+	for parent != nilIndex {
+		path = append(path, parent)
+
+		if key < t.nodes[parent].key {
+			if t.nodes[parent].left == nilIndex {
+				t.nodes[parent].left = node
+				break
+			}
+
+			parent = t.nodes[parent].left
+		} else {
+			if t.nodes[parent].right == nilIndex {
+				t.nodes[parent].right = node
+				break
+			}
+
+			parent = t.nodes[parent].right
+		}
+	}
+
+	if t.root == nilIndex {
+		t.root = node
+	}
+
+	t.size++
+	t.rebalancePath(path)
+}
+
+// Removes a node walking down from the root
+// and then rebalances the path bottom-up
+func (t *ArenaAVLTree[TKey, TValue]) remove(key TKey) {
+	path := t.pathStack()
+	node := t.root
+
+	for {
+		if node == nilIndex {
+			return
+		}
+
+		n := &t.nodes[node]
+
+		if key < n.key {
+			path = append(path, node)
+			node = n.left
+		} else if key > n.key {
+			path = append(path, node)
+			node = n.right
+		} else {
+			break
+		}
+	}
+
+	n := &t.nodes[node]
+
+	if n.left != nilIndex && n.right != nilIndex {
+		// node to delete found with both children;
+		// replace values with smallest node of the right sub-tree
+		path = append(path, node)
+		rightMinNode := n.right
+
+		for t.nodes[rightMinNode].left != nilIndex {
+			path = append(path, rightMinNode)
+			rightMinNode = t.nodes[rightMinNode].left
+		}
+
+		n.key = t.nodes[rightMinNode].key
+		n.Value = t.nodes[rightMinNode].Value
+		// delete smallest node that we replaced
+		node = rightMinNode
+		n = &t.nodes[node]
+	}
+
+	// node has at most one child
+	// which takes its place
+	child := n.left
+
+	if child == nilIndex {
+		child = n.right
+	}
+
+	t.replaceChild(path, node, child)
+	t.release(node)
+	t.size--
+	t.rebalancePath(path)
+}
+
+// Makes the parent of the node, which is the last one
+// in the path, reference the new child instead of the node
+func (t *ArenaAVLTree[TKey, TValue]) replaceChild(path []int32, node, child int32) {
+	if len(path) == 0 {
+		t.root = child
+		return
+	}
+
+	parent := &t.nodes[path[len(path)-1]]
+
+	if parent.left == node {
+		parent.left = child
+	} else {
+		parent.right = child
+	}
+}
+
+// Rebalances the nodes in the path starting
+// from the deepest one. Stops as soon as
+// the height of a subtree stays the same
+func (t *ArenaAVLTree[TKey, TValue]) rebalancePath(path []int32) {
+	for i := len(path) - 1; i >= 0; i-- {
+		node := path[i]
+		height := t.nodes[node].height
+		subtree := t.rebalance(node)
+
+		if subtree != node {
+			t.replaceChild(path[:i], node, subtree)
+		}
+
+		if t.nodes[subtree].height == height {
+			break
+		}
+	}
+}
+
+func (t *ArenaAVLTree[TKey, TValue]) getHeight(node int32) int32 {
+	if node == nilIndex {
+		return 0
+	}
+
+	return t.nodes[node].height
+}
+
+func (t *ArenaAVLTree[TKey, TValue]) recalculateHeight(node int32) {
+	n := &t.nodes[node]
+	n.height = 1 + maxElem(t.getHeight(n.left), t.getHeight(n.right))
+}
+
+// Checks if node is balanced and rebalance
+func (t *ArenaAVLTree[TKey, TValue]) rebalance(node int32) int32 {
+	t.recalculateHeight(node)
+	n := &t.nodes[node]
+
+	// check balance factor and rotateLeft if right-heavy and rotateRight if left-heavy
+	balanceFactor := t.getHeight(n.left) - t.getHeight(n.right)
+	if balanceFactor == -2 {
+		// check if child is left-heavy and rotateRight first
+		right := &t.nodes[n.right]
+		if t.getHeight(right.left) > t.getHeight(right.right) {
+			n.right = t.rotateRight(n.right)
+		}
+		return t.rotateLeft(node)
+	} else if balanceFactor == 2 {
+		// check if child is right-heavy and rotateLeft first
+		left := &t.nodes[n.left]
+		if t.getHeight(left.right) > t.getHeight(left.left) {
+			n.left = t.rotateLeft(n.left)
+		}
+		return t.rotateRight(node)
+	}
+	return node
+}
+
+// Rotate nodes left to balance node
+func (t *ArenaAVLTree[TKey, TValue]) rotateLeft(node int32) int32 {
+	newRoot := t.nodes[node].right
+	t.nodes[node].right = t.nodes[newRoot].left
+	t.nodes[newRoot].left = node
+
+	t.recalculateHeight(node)
+	t.recalculateHeight(newRoot)
+	return newRoot
+}
+
+// Rotate nodes right to balance node
+func (t *ArenaAVLTree[TKey, TValue]) rotateRight(node int32) int32 {
+	newRoot := t.nodes[node].left
+	t.nodes[node].left = t.nodes[newRoot].right
+	t.nodes[newRoot].right = node
+
+	t.recalculateHeight(node)
+	t.recalculateHeight(newRoot)
+	return newRoot
+}
+
+// NewArenaAVLTree creates a new arena
+// AVL tree with the specified options.
+func NewArenaAVLTree[
+	TKey constraints.Ordered, TValue any,
+](
+	options ...ArenaAVLTreeOption[TKey, TValue],
+) (
+	*ArenaAVLTree[TKey, TValue], error,
+) {
+	tree := &ArenaAVLTree[TKey, TValue]{}
+
+	for i := 0; i < len(options); i++ {
+		option := options[i]
+		err := option(tree)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tree, nil
+}
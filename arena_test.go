@@ -0,0 +1,108 @@
+package avltree_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zergon321/go-avltree"
+)
+
+type arenaSubject struct {
+	tree *avltree.ArenaAVLTree[int, int]
+}
+
+func (s arenaSubject) Add(key, value int) {
+	s.tree.Add(key, value)
+}
+
+func (s arenaSubject) AddOrUpdate(key, value int, upd func(oldValue int) (int, error)) error {
+	return s.tree.AddOrUpdate(key, value, upd)
+}
+
+func (s arenaSubject) Remove(key int) {
+	s.tree.Remove(key)
+}
+
+func (s arenaSubject) Update(oldKey, newKey, newValue int) bool {
+	return s.tree.Update(oldKey, newKey, newValue)
+}
+
+func (s arenaSubject) Search(key int) (int, bool) {
+	node := s.tree.Search(key)
+
+	if node == nil {
+		return 0, false
+	}
+
+	return node.Value, true
+}
+
+func (s arenaSubject) Entries() []fuzzEntry {
+	entries := []fuzzEntry{}
+	s.tree.VisitInOrder(func(node *avltree.ArenaAVLNode[int, int]) error {
+		entries = append(entries, fuzzEntry{key: node.Key(), value: node.Value})
+		return nil
+	})
+
+	return entries
+}
+
+func (s arenaSubject) Validate() error {
+	return s.tree.Validate()
+}
+
+func FuzzArenaAVLTree(f *testing.F) {
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tree, err := avltree.NewArenaAVLTree[int, int]()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		runOperations(t, arenaSubject{tree: tree}, data)
+	})
+}
+
+func TestArenaVisitInOrder(t *testing.T) {
+	tree, err := avltree.NewArenaAVLTree(
+		avltree.ArenaAVLTreeOptionInitialCapacity[int, string](8))
+	assert.Nil(t, err)
+
+	for i := 8; i > 0; i-- {
+		tree.Add(i, string(rune('a'+i-1)))
+	}
+
+	tree.Remove(4)
+	str := ""
+
+	err = tree.VisitInOrder(func(node *avltree.ArenaAVLNode[int, string]) error {
+		str += node.Value
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "abcefgh", str)
+
+	tree.Clear()
+	assert.Nil(t, tree.Search(1))
+	assert.Nil(t, tree.Validate())
+}
+
+func TestArenaNegativeCapacity(t *testing.T) {
+	_, err := avltree.NewArenaAVLTree(
+		avltree.ArenaAVLTreeOptionInitialCapacity[int, int](-1))
+
+	var capErr *avltree.ErrorNegativeCapacity
+	assert.ErrorAs(t, err, &capErr)
+}
+
+func BenchmarkArenaAVLInsert(b *testing.B) {
+	tree := &avltree.ArenaAVLTree[int, int]{}
+
+	for i := 0; i < b.N; i++ {
+		value := i * 7919 % (1 << 20)
+		tree.Add(value, value)
+	}
+}
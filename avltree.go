@@ -2,94 +2,413 @@ package avltree
 
 import (
 	"fmt"
+	"sync/atomic"
 
 	"github.com/zergon321/mempool"
 	"golang.org/x/exp/constraints"
 )
 
+// maxHeight bounds the height of an AVL tree:
+// a tree of height h holds at least F(h+2)-1 nodes
+// which exceeds the address space for h > 90.
+// Iterative operations use it to size their stacks.
+const maxHeight = 92
+
 // AVLTree[TKey constraints.Ordered, TValue any] structure. Public methods are Add, Remove, Update, Search, DisplayTreeInOrder.
 type AVLTree[TKey constraints.Ordered, TValue any] struct {
 	root *AVLNode[TKey, TValue]
-	pool *mempool.Pool[*AVLNode[TKey, TValue]]
+	pool NodeAllocator[*AVLNode[TKey, TValue]]
+	// path is the scratch stack
+	// of child slots used by writes.
+	path []**AVLNode[TKey, TValue]
+	// parentLinks enables maintaining
+	// the parent pointers of the nodes.
+	parentLinks bool
+	// size is the number
+	// of nodes in the tree.
+	size int
+	// encode turns the entries into bytes
+	// for hashing. If it's nil, the nodes
+	// don't maintain hashes.
+	encode func(key TKey, value TValue) []byte
+	// watchers receive the changes
+	// of the key ranges.
+	watchers []*Watcher[TKey, TValue]
+	// hooks is nil if no
+	// hooks are registered.
+	hooks *hooks[TKey, TValue]
+	// counters accumulate
+	// the statistics.
+	counters treeCounters
+	// bound is nil if the tree
+	// size is unlimited.
+	bound *avlBound[TKey, TValue]
+	// deferred holds the changes made by
+	// a transaction commit until the whole
+	// batch is applied. The eviction is
+	// deferred as well while deferring is set.
+	deferred  []Event[TKey, TValue]
+	deferring bool
+	// versions tracks the snapshots sharing
+	// the nodes. It's nil until the first
+	// snapshot is taken.
+	versions *avlVersions[TKey, TValue]
 }
 
+// Erase returns all the nodes
+// to the pool and resets the tree.
 func (t *AVLTree[TKey, TValue]) Erase() error {
-	t.root = nil
+	t.Clear()
 	t.pool = nil
 
 	return nil
 }
 
 func (t *AVLTree[TKey, TValue]) SetPool(pool *mempool.Pool[*AVLNode[TKey, TValue]]) {
-	t.pool = pool
+	t.pool = MempoolAllocator(pool)
+}
+
+// SetAllocator makes the tree take the new
+// nodes from the allocator and put the removed
+// nodes back to it. The nodes currently in
+// the tree must not be owned by a different
+// allocator.
+func (t *AVLTree[TKey, TValue]) SetAllocator(alloc NodeAllocator[*AVLNode[TKey, TValue]]) {
+	t.pool = alloc
 }
 
 func (t *AVLTree[TKey, TValue]) Add(key TKey, value TValue) {
-	t.root = t.root.add(key, value, t.pool)
+	t.add(key, value)
 }
 
+// AddOrUpdate adds the value by the key if there's no such key
+// in the tree. Otherwise it sets the value returned by upd for
+// the current value. If upd fails, the tree stays unchanged.
 func (t *AVLTree[TKey, TValue]) AddOrUpdate(
 	key TKey, value TValue,
 	upd func(oldValue TValue) (TValue, error),
 ) error {
-	root, err := t.root.addOrUpdate(key, value, upd, t.pool)
+	path, slot := t.find(key)
 
-	if err != nil {
-		return err
+	if node := *slot; node != nil {
+		// if same key exists update value
+		value, err := upd(node.Value)
+
+		if err != nil {
+			return err
+		}
+
+		t.setValue(path, slot, value)
+
+		return nil
 	}
 
-	t.root = root
+	t.attach(path, slot, t.newNode(key, value))
 
 	return nil
 }
 
 func (t *AVLTree[TKey, TValue]) Remove(key TKey) {
-	t.root = t.root.remove(key, t.pool)
+	t.remove(key)
 }
 
-func (t *AVLTree[TKey, TValue]) Update(oldKey TKey, newKey TKey, newValue TValue) {
-	t.root = t.root.remove(oldKey, t.pool)
-	t.root = t.root.add(newKey, newValue, t.pool)
+// Update moves the entry of the old key to the new key
+// and sets the new value. The node of the old key is reused
+// for the new key. If the new key already exists, its value
+// is overwritten. Returns false and leaves the tree unchanged
+// if there's no old key in the tree.
+func (t *AVLTree[TKey, TValue]) Update(oldKey TKey, newKey TKey, newValue TValue) bool {
+	existed, _ := t.update(oldKey, newKey, newValue, false)
+	return existed
 }
 
+// UpdateStrict works like Update but fails with
+// ErrorKeyExists leaving the tree unchanged
+// if the new key already exists.
+func (t *AVLTree[TKey, TValue]) UpdateStrict(oldKey TKey, newKey TKey, newValue TValue) (bool, error) {
+	return t.update(oldKey, newKey, newValue, true)
+}
+
+// Search returns the node of the key or nil if there's
+// no such key in the tree. The node stays valid until
+// the key is removed or, if a snapshot of the tree is
+// taken, until the next write to the tree. If the
+// tree evicts by EvictLRU, Search records the use
+// of the key, so it must be locked exclusively
+// rather than shared with the other readers.
 func (t *AVLTree[TKey, TValue]) Search(key TKey) (node *AVLNode[TKey, TValue]) {
-	return t.root.search(key)
+	node = t.root.search(key)
+
+	// searching uses the entry
+	// for the LRU eviction
+	if node != nil && t.bound != nil && t.bound.elements != nil {
+		t.bound.touch(key)
+	}
+
+	return node
 }
 
 func (t *AVLTree[TKey, TValue]) VisitInOrder(visit func(node *AVLNode[TKey, TValue]) error) error {
-	return t.visitInOrder(t.root, visit)
+	return t.visitInOrder(visit)
 }
 
-func (t *AVLTree[TKey, TValue]) visitInOrder(node *AVLNode[TKey, TValue], visit func(node *AVLNode[TKey, TValue]) error) error {
-	if node == nil {
-		return nil
-	}
+// Visits the nodes in the ascending order of keys
+func (t *AVLTree[TKey, TValue]) visitInOrder(visit func(node *AVLNode[TKey, TValue]) error) error {
+	var stack [maxHeight]*AVLNode[TKey, TValue]
+	top := 0
+	node := t.root
+
+	for node != nil || top > 0 {
+		for node != nil {
+			stack[top] = node
+			top++
+			node = node.left
+		}
 
-	if node.left != nil {
-		err := t.visitInOrder(node.left, visit)
+		top--
+		node = stack[top]
+
+		err := visit(node)
 
 		if err != nil {
 			return err
 		}
+
+		node = node.right
 	}
 
-	if node != nil {
-		err := visit(node)
+	return nil
+}
 
-		if err != nil {
-			return err
+// Returns the empty scratch path stack of the tree
+func (t *AVLTree[TKey, TValue]) pathStack() []**AVLNode[TKey, TValue] {
+	if t.path == nil {
+		t.path = make([]**AVLNode[TKey, TValue], 0, maxHeight)
+	}
+
+	return t.path[:0]
+}
+
+// Walks down from the root looking for the key. Returns
+// the path of slots leading to the slot which either
+// references the node with the key or is empty
+func (t *AVLTree[TKey, TValue]) find(key TKey) ([]**AVLNode[TKey, TValue], **AVLNode[TKey, TValue]) {
+	t.collect()
+
+	path := t.pathStack()
+	slot := &t.root
+	shared := t.shared()
+
+	for *slot != nil {
+		n := *slot
+
+		if shared {
+			n = t.own(slot)
+		}
+
+		if key < n.key {
+			path = append(path, slot)
+			slot = &n.left
+		} else if key > n.key {
+			path = append(path, slot)
+			slot = &n.right
+		} else {
+			break
 		}
 	}
 
-	if node.right != nil {
-		err := t.visitInOrder(node.right, visit)
+	return path, slot
+}
 
-		if err != nil {
-			return err
+// Attaches the node to the empty slot
+// and then rebalances the path bottom-up
+func (t *AVLTree[TKey, TValue]) attach(path []**AVLNode[TKey, TValue], slot **AVLNode[TKey, TValue], node *AVLNode[TKey, TValue]) {
+	*slot = node
+	t.size++
+
+	if t.parentLinks && len(path) > 0 {
+		node.parent = *path[len(path)-1]
+	}
+
+	t.rebalancePath(path)
+
+	var zeroValTValue TValue
+	t.notify(EventInsert, node.key, zeroValTValue, node.Value)
+
+	if t.bound != nil && !t.deferring {
+		t.evict(node.key)
+	}
+}
+
+// Adds a new node or updates
+// the value of the existing one
+func (t *AVLTree[TKey, TValue]) add(key TKey, value TValue) {
+	path, slot := t.find(key)
+
+	if *slot != nil {
+		// if same key exists update value
+		t.setValue(path, slot, value)
+		return
+	}
+
+	t.attach(path, slot, t.newNode(key, value))
+}
+
+// Removes a node and returns it to the pool
+func (t *AVLTree[TKey, TValue]) remove(key TKey) {
+	path, slot := t.find(key)
+
+	if *slot == nil {
+		return
+	}
+
+	t.release(t.unlink(path, slot))
+}
+
+// Moves the node of the old key to the new key
+func (t *AVLTree[TKey, TValue]) update(oldKey TKey, newKey TKey, newValue TValue, strict bool) (bool, error) {
+	path, slot := t.find(oldKey)
+	node := *slot
+
+	if node == nil {
+		return false, nil
+	}
+
+	if newKey == oldKey {
+		t.setValue(path, slot, newValue)
+		return true, nil
+	}
+
+	if strict && t.root.search(newKey) != nil {
+		return true, &ErrorKeyExists{
+			key: newKey,
 		}
 	}
 
-	return nil
+	node = t.unlink(path, slot)
+	node.key = newKey
+	node.Value = newValue
+	node.height = 1
+	t.hashEntry(node)
+
+	path, slot = t.find(newKey)
+
+	if *slot != nil {
+		t.setValue(path, slot, newValue)
+		t.release(node)
+
+		return true, nil
+	}
+
+	t.attach(path, slot, node)
+
+	return true, nil
+}
+
+// Unlinks the node referenced by the slot and rebalances
+// the path leading to the slot. The node is relinked
+// rather than copied so handles to the other nodes stay
+// valid. It's returned detached but not released
+func (t *AVLTree[TKey, TValue]) unlink(path []**AVLNode[TKey, TValue], slot **AVLNode[TKey, TValue]) *AVLNode[TKey, TValue] {
+	node := *slot
+
+	if node.left != nil && node.right != nil {
+		// node to delete found with both children;
+		// replace it with smallest node of the right sub-tree
+		target := len(path)
+		path = append(path, slot)
+		minSlot := &node.right
+		t.own(minSlot)
+
+		for (*minSlot).left != nil {
+			path = append(path, minSlot)
+			minSlot = &(*minSlot).left
+			t.own(minSlot)
+		}
+
+		rightMinNode := *minSlot
+		*minSlot = rightMinNode.right
+
+		if t.parentLinks && rightMinNode.right != nil {
+			rightMinNode.right.parent = rightMinNode.parent
+		}
+
+		rightMinNode.left = node.left
+		rightMinNode.right = node.right
+		rightMinNode.height = node.height
+		*slot = rightMinNode
+
+		if t.parentLinks {
+			rightMinNode.parent = node.parent
+			rightMinNode.left.parent = rightMinNode
+
+			if rightMinNode.right != nil {
+				rightMinNode.right.parent = rightMinNode
+			}
+		}
+
+		// the path went through the right
+		// child slot of the removed node
+		if len(path) > target+1 {
+			path[target+1] = &rightMinNode.right
+		}
+	} else {
+		// node has at most one child
+		// which takes its place
+		child := node.left
+
+		if child == nil {
+			child = node.right
+		}
+
+		*slot = child
+
+		if t.parentLinks && child != nil {
+			child.parent = node.parent
+		}
+	}
+
+	t.rebalancePath(path)
+	t.size--
+
+	node.left = nil
+	node.right = nil
+	node.parent = nil
+
+	var zeroValTValue TValue
+	t.notify(EventDelete, node.key, node.Value, zeroValTValue)
+
+	return node
+}
+
+// Returns the node to the pool if it's set.
+// The error of the pool is ignored: the node
+// is out of the tree and the GC takes it
+func (t *AVLTree[TKey, TValue]) release(node *AVLNode[TKey, TValue]) {
+	if t.pool != nil {
+		t.pool.Put(node)
+		t.counters.pooled++
+	}
+}
+
+// Clear removes all the nodes from
+// the tree returning them to the pool.
+func (t *AVLTree[TKey, TValue]) Clear() {
+	t.collect()
+
+	root := t.root
+	t.root = nil
+	t.size = 0
+
+	if t.pool != nil || t.shared() || len(t.watchers) > 0 || t.hooks != nil {
+		t.releaseAll(root)
+	}
+}
+
+// Len returns the number
+// of entries in the tree.
+func (t *AVLTree[TKey, TValue]) Len() int {
+	return t.size
 }
 
 func (t *AVLTree[TKey, TValue]) DisplayInOrder() {
@@ -105,6 +424,16 @@ type AVLNode[TKey constraints.Ordered, TValue any] struct {
 	height int
 	left   *AVLNode[TKey, TValue]
 	right  *AVLNode[TKey, TValue]
+	// parent is only maintained if the tree
+	// is created with parent links enabled.
+	parent *AVLNode[TKey, TValue]
+	// hash is only maintained if the tree
+	// is created with hashing enabled.
+	hash *nodeHash
+	// refs is the number of the snapshots
+	// and the nodes referencing the node
+	// besides its owner.
+	refs atomic.Int32
 }
 
 // Key returns the key of the AVL tree node.
@@ -125,146 +454,86 @@ func (node *AVLNode[TKey, TValue]) Erase() error {
 	node.height = 0
 	node.left = nil
 	node.right = nil
+	node.parent = nil
 
-	return nil
-}
-
-// Adds a new node
-func (n *AVLNode[TKey, TValue]) add(key TKey, value TValue, pool *mempool.Pool[*AVLNode[TKey, TValue]]) *AVLNode[TKey, TValue] {
-	if n == nil {
-		if pool != nil {
-			node := pool.Get()
+	// the hash is kept so the pooled
+	// nodes don't reallocate it
+	if node.hash != nil {
+		*node.hash = nodeHash{}
+	}
 
-			node.key = key
-			node.Value = value
-			node.height = 1
+	node.refs.Store(0)
 
-			return node
-		}
+	return nil
+}
 
-		return &AVLNode[TKey, TValue]{key, value, 1, nil, nil}
+// Next returns the node with the next key
+// in the tree or nil if the node is the last one.
+// The tree must be created with parent links enabled.
+func (node *AVLNode[TKey, TValue]) Next() *AVLNode[TKey, TValue] {
+	if node.right != nil {
+		return node.right.findSmallest()
 	}
 
-	if key < n.key {
-		n.left = n.left.add(key, value, pool)
-	} else if key > n.key {
-		n.right = n.right.add(key, value, pool)
-	} else {
-		// if same key exists update value
-		n.Value = value
+	for node.parent != nil && node == node.parent.right {
+		node = node.parent
 	}
-	return n.rebalanceTree()
-}
-
-func (n *AVLNode[TKey, TValue]) addOrUpdate(
-	key TKey, value TValue,
-	upd func(oldValue TValue) (TValue, error),
-	pool *mempool.Pool[*AVLNode[TKey, TValue]],
-) (*AVLNode[TKey, TValue], error) {
-	var err error
 
-	if n == nil {
-		if pool != nil {
-			node := pool.Get()
-
-			node.key = key
-			node.Value = value
-			node.height = 1
+	return node.parent
+}
 
-			return node, nil
-		}
+// Prev returns the node with the previous key
+// in the tree or nil if the node is the first one.
+// The tree must be created with parent links enabled.
+func (node *AVLNode[TKey, TValue]) Prev() *AVLNode[TKey, TValue] {
+	if node.left != nil {
+		return node.left.findLargest()
+	}
 
-		return &AVLNode[TKey, TValue]{key, value, 1, nil, nil}, nil
+	for node.parent != nil && node == node.parent.left {
+		node = node.parent
 	}
 
-	if key < n.key {
-		n.left, err = n.left.addOrUpdate(key, value, upd, pool)
+	return node.parent
+}
 
-		if err != nil {
-			return nil, err
-		}
-	} else if key > n.key {
-		n.right, err = n.right.addOrUpdate(key, value, upd, pool)
+// Creates a new node taking it from the pool if any
+func (t *AVLTree[TKey, TValue]) newNode(key TKey, value TValue) *AVLNode[TKey, TValue] {
+	if t.pool != nil {
+		node := t.pool.Get()
+		t.counters.taken()
 
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		// if same key exists update value
-		value, err := upd(n.Value)
+		node.key = key
+		node.Value = value
+		node.height = 1
+		t.hashEntry(node)
 
-		if err != nil {
-			return nil, err
-		}
+		return node
+	}
 
-		n.Value = value
+	node := &AVLNode[TKey, TValue]{
+		key:    key,
+		Value:  value,
+		height: 1,
 	}
+	t.hashEntry(node)
 
-	return n.rebalanceTree(), nil
+	return node
 }
 
-// Removes a node
-func (n *AVLNode[TKey, TValue]) remove(key TKey, pool *mempool.Pool[*AVLNode[TKey, TValue]]) *AVLNode[TKey, TValue] {
-	if n == nil {
-		return nil
-	}
-	if key < n.key {
-		n.left = n.left.remove(key, pool)
-	} else if key > n.key {
-		n.right = n.right.remove(key, pool)
-	} else {
-		if n.left != nil && n.right != nil {
-			// node to delete found with both children;
-			// replace values with smallest node of the right sub-tree
-			rightMinNode := n.right.findSmallest()
-			n.key = rightMinNode.key
-			n.Value = rightMinNode.Value
-			// delete smallest node that we replaced
-			n.right = n.right.remove(rightMinNode.key, pool)
-		} else if n.left != nil {
-			// node only has left child
-			node := n
+// Searches for a node
+func (n *AVLNode[TKey, TValue]) search(key TKey) *AVLNode[TKey, TValue] {
+	for n != nil {
+		if key < n.key {
 			n = n.left
-
-			if pool != nil {
-				pool.Put(node)
-			}
-		} else if n.right != nil {
-			// node only has right child
-			node := n
+		} else if key > n.key {
 			n = n.right
-
-			if pool != nil {
-				pool.Put(node)
-			}
 		} else {
-			// node has no children
-			node := n
-			n = nil
-
-			if pool != nil {
-				pool.Put(node)
-			}
-
 			return n
 		}
-
 	}
-	return n.rebalanceTree()
-}
 
-// Searches for a node
-func (n *AVLNode[TKey, TValue]) search(key TKey) *AVLNode[TKey, TValue] {
-	if n == nil {
-		return nil
-	}
-	if key < n.key {
-		return n.left.search(key)
-	} else if key > n.key {
-		return n.right.search(key)
-	} else {
-		return n
-	}
+	return nil
 }
 
 // Displays nodes left-depth first (used for debugging)
@@ -289,50 +558,109 @@ func (n *AVLNode[TKey, TValue]) recalculateHeight() {
 	n.height = 1 + maxElem(n.left.getHeight(), n.right.getHeight())
 }
 
+// Rebalances the nodes referenced by the path slots
+// starting from the deepest one. Stops as soon as
+// the height of a subtree stays the same because
+// nothing changes for its ancestors in that case
+// unless their hashes have to be recalculated
+func (t *AVLTree[TKey, TValue]) rebalancePath(path []**AVLNode[TKey, TValue]) {
+	for i := len(path) - 1; i >= 0; i-- {
+		height := (*path[i]).height
+		*path[i] = t.rebalance(*path[i])
+
+		if (*path[i]).height == height && t.encode == nil {
+			break
+		}
+	}
+
+	for i := range path {
+		path[i] = nil
+	}
+}
+
 // Checks if node is balanced and rebalance
-func (n *AVLNode[TKey, TValue]) rebalanceTree() *AVLNode[TKey, TValue] {
+func (t *AVLTree[TKey, TValue]) rebalance(n *AVLNode[TKey, TValue]) *AVLNode[TKey, TValue] {
 	if n == nil {
 		return n
 	}
 	n.recalculateHeight()
+	n.recalculateHash()
 
 	// check balance factor and rotateLeft if right-heavy and rotateRight if left-heavy
 	balanceFactor := n.left.getHeight() - n.right.getHeight()
 	if balanceFactor == -2 {
 		// check if child is left-heavy and rotateRight first
 		if n.right.left.getHeight() > n.right.right.getHeight() {
-			n.right = n.right.rotateRight()
+			t.counters.doubleRotations++
+			n.right = t.rotateRight(t.own(&n.right))
+		} else {
+			t.counters.singleRotations++
 		}
-		return n.rotateLeft()
+		return t.rotateLeft(n)
 	} else if balanceFactor == 2 {
 		// check if child is right-heavy and rotateLeft first
 		if n.left.right.getHeight() > n.left.left.getHeight() {
-			n.left = n.left.rotateLeft()
+			t.counters.doubleRotations++
+			n.left = t.rotateLeft(t.own(&n.left))
+		} else {
+			t.counters.singleRotations++
 		}
-		return n.rotateRight()
+		return t.rotateRight(n)
 	}
 	return n
 }
 
 // Rotate nodes left to balance node
-func (n *AVLNode[TKey, TValue]) rotateLeft() *AVLNode[TKey, TValue] {
-	newRoot := n.right
+func (t *AVLTree[TKey, TValue]) rotateLeft(n *AVLNode[TKey, TValue]) *AVLNode[TKey, TValue] {
+	newRoot := t.own(&n.right)
 	n.right = newRoot.left
 	newRoot.left = n
 
+	if t.parentLinks {
+		if n.right != nil {
+			n.right.parent = n
+		}
+
+		newRoot.parent = n.parent
+		n.parent = newRoot
+	}
+
 	n.recalculateHeight()
 	newRoot.recalculateHeight()
+	n.recalculateHash()
+	newRoot.recalculateHash()
+
+	if t.hooks != nil {
+		t.hooks.rotated(n.key, newRoot.key)
+	}
+
 	return newRoot
 }
 
 // Rotate nodes right to balance node
-func (n *AVLNode[TKey, TValue]) rotateRight() *AVLNode[TKey, TValue] {
-	newRoot := n.left
+func (t *AVLTree[TKey, TValue]) rotateRight(n *AVLNode[TKey, TValue]) *AVLNode[TKey, TValue] {
+	newRoot := t.own(&n.left)
 	n.left = newRoot.right
 	newRoot.right = n
 
+	if t.parentLinks {
+		if n.left != nil {
+			n.left.parent = n
+		}
+
+		newRoot.parent = n.parent
+		n.parent = newRoot
+	}
+
 	n.recalculateHeight()
 	newRoot.recalculateHeight()
+	n.recalculateHash()
+	newRoot.recalculateHash()
+
+	if t.hooks != nil {
+		t.hooks.rotated(n.key, newRoot.key)
+	}
+
 	return newRoot
 }
 
@@ -345,6 +673,15 @@ func (n *AVLNode[TKey, TValue]) findSmallest() *AVLNode[TKey, TValue] {
 	}
 }
 
+// Finds the largest child (based on the key) for the current node
+func (n *AVLNode[TKey, TValue]) findLargest() *AVLNode[TKey, TValue] {
+	for n.right != nil {
+		n = n.right
+	}
+
+	return n
+}
+
 // Returns maxElem number - TODO: std lib seemed to only have a method for floats!
 func maxElem[TKey constraints.Ordered](a TKey, b TKey) TKey {
 	if a > b {
@@ -1,6 +1,7 @@
 package avltree_test
 
 import (
+	"errors"
 	"math/rand"
 	"sort"
 	"strconv"
@@ -38,6 +39,157 @@ func TestVisitInOrder(t *testing.T) {
 	assert.Equal(t, "12345678", str)
 }
 
+func TestNextPrev(t *testing.T) {
+	tree, err := avltree.NewAVLTree(
+		avltree.AVLTreeOptionWithParentLinks[int, int]())
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		tree.Add(rand.Intn(1000), i)
+	}
+
+	for i := 0; i < 50; i++ {
+		tree.Remove(rand.Intn(1000))
+	}
+
+	keys := []int{}
+	tree.VisitInOrder(func(node *avltree.AVLNode[int, int]) error {
+		keys = append(keys, node.Key())
+		return nil
+	})
+
+	node := tree.Search(keys[0])
+
+	for i := 0; i < len(keys); i++ {
+		assert.Equal(t, keys[i], node.Key())
+		node = node.Next()
+	}
+
+	assert.Nil(t, node)
+	node = tree.Search(keys[len(keys)-1])
+
+	for i := len(keys) - 1; i >= 0; i-- {
+		assert.Equal(t, keys[i], node.Key())
+		node = node.Prev()
+	}
+
+	assert.Nil(t, node)
+}
+
+func TestRemoveKeepsHandles(t *testing.T) {
+	tree, err := avltree.NewAVLTree[int, int]()
+	assert.Nil(t, err)
+
+	for i := 0; i < 64; i++ {
+		tree.Add(i, i)
+	}
+
+	handles := map[int]*avltree.AVLNode[int, int]{}
+
+	for i := 0; i < 64; i++ {
+		handles[i] = tree.Search(i)
+	}
+
+	for i := 0; i < 64; i += 3 {
+		tree.Remove(i)
+		delete(handles, i)
+
+		for key, node := range handles {
+			assert.Equal(t, key, node.Key())
+			assert.Equal(t, key, node.Value)
+			assert.Same(t, node, tree.Search(key))
+		}
+	}
+
+	assert.Nil(t, tree.Validate())
+}
+
+func TestUpdate(t *testing.T) {
+	tree, err := avltree.NewAVLTree[int, string]()
+	assert.Nil(t, err)
+
+	tree.Add(1, "a")
+	tree.Add(2, "b")
+	node := tree.Search(1)
+
+	assert.False(t, tree.Update(3, 4, "d"))
+	assert.Nil(t, tree.Search(4))
+
+	assert.True(t, tree.Update(1, 5, "e"))
+	assert.Nil(t, tree.Search(1))
+	assert.Same(t, node, tree.Search(5))
+	assert.Equal(t, "e", node.Value)
+
+	existed, err := tree.UpdateStrict(5, 2, "x")
+	assert.True(t, existed)
+
+	var existsErr *avltree.ErrorKeyExists
+	assert.ErrorAs(t, err, &existsErr)
+	assert.Equal(t, "b", tree.Search(2).Value)
+	assert.Equal(t, "e", tree.Search(5).Value)
+
+	existed, err = tree.UpdateStrict(5, 6, "f")
+	assert.True(t, existed)
+	assert.Nil(t, err)
+	assert.Equal(t, "f", tree.Search(6).Value)
+	assert.Nil(t, tree.Validate())
+}
+
+func TestAddOrUpdateFailureLeavesTreeUnchanged(t *testing.T) {
+	tree, err := avltree.NewAVLTree(
+		avltree.AVLTreeOptionWithParentLinks[int, int]())
+	assert.Nil(t, err)
+
+	for i := 0; i < 127; i++ {
+		tree.Add(i, i)
+	}
+
+	errFailed := errors.New("failed")
+	fail := func(oldValue int) (int, error) {
+		return 0, errFailed
+	}
+
+	// every key sits at a different
+	// depth of the complete tree
+	for i := 0; i < 127; i++ {
+		err = tree.AddOrUpdate(i, -1, fail)
+		assert.ErrorIs(t, err, errFailed)
+		assert.Nil(t, tree.Validate())
+
+		for j := 0; j < 127; j++ {
+			node := tree.Search(j)
+			assert.NotNil(t, node)
+			assert.Equal(t, j, node.Value)
+		}
+	}
+}
+
+func TestClearReturnsNodesToPool(t *testing.T) {
+	created := 0
+	pool, err := mempool.NewPool(func() *avltree.AVLNode[int, int] {
+		created++
+		return &avltree.AVLNode[int, int]{}
+	})
+	assert.Nil(t, err)
+
+	tree, err := avltree.NewAVLTree(avltree.AVLTreeOptionWithMemoryPool(pool))
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		tree.Add(i, i)
+	}
+
+	tree.Clear()
+	assert.Nil(t, tree.Search(1))
+
+	for i := 0; i < 100; i++ {
+		tree.Add(i, i)
+	}
+
+	assert.Equal(t, 100, created)
+	assert.Nil(t, tree.Validate())
+}
+
 func BenchmarkRBInsert(b *testing.B) {
 	tree := rb.NewTree[int, int]()
 
@@ -120,3 +272,50 @@ func BenchmarkAVLInsertThenRemoveMemoryPool(b *testing.B) {
 		tree.Remove(value)
 	}
 }
+
+func BenchmarkAVLSearch(b *testing.B) {
+	tree := &avltree.AVLTree[int, int]{}
+
+	for i := 0; i < 1<<16; i++ {
+		tree.Add(i, i)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		tree.Search(i & (1<<16 - 1))
+	}
+}
+
+func BenchmarkAVLVisitInOrder(b *testing.B) {
+	tree := &avltree.AVLTree[int, int]{}
+
+	for i := 0; i < 1<<16; i++ {
+		tree.Add(i, i)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		tree.VisitInOrder(func(node *avltree.AVLNode[int, int]) error {
+			return nil
+		})
+	}
+}
+
+func BenchmarkAVLInsertThenRemoveFilled(b *testing.B) {
+	tree := &avltree.AVLTree[int, int]{}
+
+	for i := 0; i < 1<<16; i++ {
+		value := rand.Int()
+		tree.Add(value, value)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		value := rand.Int()
+		tree.Add(value, value)
+		tree.Remove(value)
+	}
+}
@@ -0,0 +1,290 @@
+package avltree
+
+import "golang.org/x/exp/constraints"
+
+// Clone returns a deep copy of the tree having
+// the same shape. The clone takes its nodes from
+// the allocator of the tree and keeps parent links
+// and hashing if enabled, unless the options say
+// otherwise.
+// If copyValue is not nil, it's used to copy
+// the values, otherwise they're assigned as is.
+// If the options bound the size of the clone,
+// it's trimmed to the bound. The LRU order is
+// taken from the tree if it tracks one,
+// otherwise the larger keys count as the more
+// recently used ones.
+func (t *AVLTree[TKey, TValue]) Clone(
+	copyValue func(value TValue) TValue,
+	options ...AVLTreeOption[TKey, TValue],
+) (*AVLTree[TKey, TValue], error) {
+	clone := &AVLTree[TKey, TValue]{
+		pool:        t.pool,
+		parentLinks: t.parentLinks,
+		encode:      t.encode,
+	}
+
+	for i := 0; i < len(options); i++ {
+		option := options[i]
+		err := option(clone)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	clone.root = clone.cloneNode(t.root, nil, copyValue)
+	clone.size = t.size
+
+	if clone.bound != nil {
+		clone.seedBound(t)
+	}
+
+	return clone, nil
+}
+
+// Fills the LRU list of the clone with
+// the keys of the source tree and evicts
+// the entries exceeding the size bound
+func (t *AVLTree[TKey, TValue]) seedBound(source *AVLTree[TKey, TValue]) {
+	bound := t.bound
+
+	if bound.elements != nil {
+		if source.bound != nil && source.bound.elements != nil {
+			for element := source.bound.lru.Back(); element != nil; element = element.Prev() {
+				bound.touch(element.Value.(TKey))
+			}
+		} else {
+			it := avlIterator[TKey, TValue]{}
+			it.reset(t.root)
+
+			for node := it.next(); node != nil; node = it.next() {
+				bound.touch(node.key)
+			}
+		}
+	}
+
+	for bound.maxSize > 0 && t.size > bound.maxSize {
+		t.evict(t.root.findLargest().key)
+	}
+}
+
+// Equal reports whether both trees hold the same
+// keys with equal values in the same order.
+// The shapes of the trees are not compared.
+func (t *AVLTree[TKey, TValue]) Equal(
+	other *AVLTree[TKey, TValue],
+	valueEq func(a, b TValue) bool,
+) bool {
+	if t.size != other.size {
+		return false
+	}
+
+	it := avlIterator[TKey, TValue]{}
+	it.reset(t.root)
+	otherIt := avlIterator[TKey, TValue]{}
+	otherIt.reset(other.root)
+
+	for {
+		node, otherNode := it.next(), otherIt.next()
+
+		if node == nil || otherNode == nil {
+			return node == otherNode
+		}
+
+		if !(node.key == otherNode.key) || !valueEq(node.Value, otherNode.Value) {
+			return false
+		}
+	}
+}
+
+// Copies the subtree node by node
+func (t *AVLTree[TKey, TValue]) cloneNode(
+	node, parent *AVLNode[TKey, TValue],
+	copyValue func(value TValue) TValue,
+) *AVLNode[TKey, TValue] {
+	if node == nil {
+		return nil
+	}
+
+	value := node.Value
+
+	if copyValue != nil {
+		value = copyValue(value)
+	}
+
+	clone := t.newNode(node.key, value)
+	clone.height = node.height
+	clone.left = t.cloneNode(node.left, clone, copyValue)
+	clone.right = t.cloneNode(node.right, clone, copyValue)
+	clone.recalculateHash()
+
+	if t.parentLinks {
+		clone.parent = parent
+	}
+
+	return clone
+}
+
+// avlIterator walks the subtree
+// in the ascending order of keys.
+type avlIterator[TKey constraints.Ordered, TValue any] struct {
+	stack [maxHeight]*AVLNode[TKey, TValue]
+	top   int
+}
+
+// Starts the walk over the subtree
+func (it *avlIterator[TKey, TValue]) reset(node *AVLNode[TKey, TValue]) {
+	it.top = 0
+	it.pushLeft(node)
+}
+
+// Pushes the node and all
+// its leftmost descendants
+func (it *avlIterator[TKey, TValue]) pushLeft(node *AVLNode[TKey, TValue]) {
+	for node != nil {
+		it.stack[it.top] = node
+		it.top++
+		node = node.left
+	}
+}
+
+// Returns the next node
+// or nil if there are none
+func (it *avlIterator[TKey, TValue]) next() *AVLNode[TKey, TValue] {
+	if it.top <= 0 {
+		return nil
+	}
+
+	it.top--
+	node := it.stack[it.top]
+	it.pushLeft(node.right)
+
+	return node
+}
+
+// Clone returns a deep copy of the tree having
+// the same shape. The clone takes its nodes from
+// the allocator of the tree and keeps parent links
+// and hashing if enabled, unless the options say
+// otherwise.
+// If copyValue is not nil, it's used to copy
+// the values, otherwise they're assigned as is.
+func (t *UnrestrictedAVLTree[TKey, TValue]) Clone(
+	copyValue func(value TValue) TValue,
+	options ...UnrestrictedAVLTreeOption[TKey, TValue],
+) (*UnrestrictedAVLTree[TKey, TValue], error) {
+	clone := &UnrestrictedAVLTree[TKey, TValue]{
+		pool:        t.pool,
+		parentLinks: t.parentLinks,
+		encode:      t.encode,
+	}
+
+	for i := 0; i < len(options); i++ {
+		option := options[i]
+		err := option(clone)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	clone.root = clone.cloneNode(t.root, nil, copyValue)
+	clone.size = t.size
+
+	return clone, nil
+}
+
+// Equal reports whether both trees hold the same
+// keys with equal values in the same order.
+// The shapes of the trees are not compared.
+func (t *UnrestrictedAVLTree[TKey, TValue]) Equal(
+	other *UnrestrictedAVLTree[TKey, TValue],
+	valueEq func(a, b TValue) bool,
+) bool {
+	if t.size != other.size {
+		return false
+	}
+
+	it := unrestrictedIterator[TKey, TValue]{}
+	it.reset(t.root)
+	otherIt := unrestrictedIterator[TKey, TValue]{}
+	otherIt.reset(other.root)
+
+	for {
+		node, otherNode := it.next(), otherIt.next()
+
+		if node == nil || otherNode == nil {
+			return node == otherNode
+		}
+
+		if !(!node.key.Less(otherNode.key) && !node.key.Greater(otherNode.key)) || !valueEq(node.Value, otherNode.Value) {
+			return false
+		}
+	}
+}
+
+// Copies the subtree node by node
+func (t *UnrestrictedAVLTree[TKey, TValue]) cloneNode(
+	node, parent *UnrestrictedAVLNode[TKey, TValue],
+	copyValue func(value TValue) TValue,
+) *UnrestrictedAVLNode[TKey, TValue] {
+	if node == nil {
+		return nil
+	}
+
+	value := node.Value
+
+	if copyValue != nil {
+		value = copyValue(value)
+	}
+
+	clone := t.newNode(node.key, value)
+	clone.height = node.height
+	clone.left = t.cloneNode(node.left, clone, copyValue)
+	clone.right = t.cloneNode(node.right, clone, copyValue)
+	clone.recalculateHash()
+
+	if t.parentLinks {
+		clone.parent = parent
+	}
+
+	return clone
+}
+
+// unrestrictedIterator walks the subtree
+// in the ascending order of keys.
+type unrestrictedIterator[TKey Comparable, TValue any] struct {
+	stack [maxHeight]*UnrestrictedAVLNode[TKey, TValue]
+	top   int
+}
+
+// Starts the walk over the subtree
+func (it *unrestrictedIterator[TKey, TValue]) reset(node *UnrestrictedAVLNode[TKey, TValue]) {
+	it.top = 0
+	it.pushLeft(node)
+}
+
+// Pushes the node and all
+// its leftmost descendants
+func (it *unrestrictedIterator[TKey, TValue]) pushLeft(node *UnrestrictedAVLNode[TKey, TValue]) {
+	for node != nil {
+		it.stack[it.top] = node
+		it.top++
+		node = node.left
+	}
+}
+
+// Returns the next node
+// or nil if there are none
+func (it *unrestrictedIterator[TKey, TValue]) next() *UnrestrictedAVLNode[TKey, TValue] {
+	if it.top <= 0 {
+		return nil
+	}
+
+	it.top--
+	node := it.stack[it.top]
+	it.pushLeft(node.right)
+
+	return node
+}
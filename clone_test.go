@@ -0,0 +1,97 @@
+package avltree_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zergon321/go-avltree"
+	"github.com/zergon321/mempool"
+)
+
+func TestClone(t *testing.T) {
+	tree, err := avltree.NewAVLTree(
+		avltree.AVLTreeOptionWithParentLinks[int, []int]())
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		tree.Add(i, []int{i})
+	}
+
+	created := 0
+	pool, err := mempool.NewPool(func() *avltree.AVLNode[int, []int] {
+		created++
+		return &avltree.AVLNode[int, []int]{}
+	})
+	assert.Nil(t, err)
+
+	clone, err := tree.Clone(func(value []int) []int {
+		return append([]int{}, value...)
+	}, avltree.AVLTreeOptionWithMemoryPool(pool))
+	assert.Nil(t, err)
+	assert.Nil(t, clone.Validate())
+	assert.Equal(t, 100, created)
+	assert.Equal(t, tree.Len(), clone.Len())
+
+	sliceEq := func(a, b []int) bool {
+		return len(a) == len(b) && a[0] == b[0]
+	}
+	assert.True(t, tree.Equal(clone, sliceEq))
+
+	// the clone doesn't share
+	// the nodes and the values
+	tree.Search(10).Value[0] = -1
+	tree.Remove(20)
+
+	assert.Equal(t, 10, clone.Search(10).Value[0])
+	assert.NotNil(t, clone.Search(20))
+	assert.Equal(t, 19, clone.Search(20).Prev().Key())
+	assert.False(t, tree.Equal(clone, sliceEq))
+}
+
+func TestEqual(t *testing.T) {
+	ascending := &avltree.AVLTree[int, int]{}
+	descending := &avltree.AVLTree[int, int]{}
+
+	for i := 0; i < 100; i++ {
+		ascending.Add(i, i)
+		descending.Add(99-i, 99-i)
+	}
+
+	intEq := func(a, b int) bool {
+		return a == b
+	}
+
+	assert.True(t, ascending.Equal(descending, intEq))
+
+	descending.Add(50, -50)
+	assert.False(t, ascending.Equal(descending, intEq))
+
+	descending.Add(50, 50)
+	descending.Add(100, 100)
+	assert.False(t, ascending.Equal(descending, intEq))
+
+	descending.Remove(0)
+	assert.False(t, ascending.Equal(descending, intEq))
+}
+
+func TestUnrestrictedCloneEqual(t *testing.T) {
+	tree := &avltree.UnrestrictedAVLTree[IntKey, int]{}
+
+	for i := 0; i < 100; i++ {
+		tree.Add(IntKey(i), i)
+	}
+
+	clone, err := tree.Clone(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, clone.Validate())
+
+	intEq := func(a, b int) bool {
+		return a == b
+	}
+
+	assert.True(t, tree.Equal(clone, intEq))
+
+	clone.Update(IntKey(5), IntKey(500), 5)
+	assert.False(t, tree.Equal(clone, intEq))
+	assert.NotNil(t, tree.Search(IntKey(5)))
+}
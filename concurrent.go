@@ -0,0 +1,849 @@
+package avltree
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/exp/constraints"
+)
+
+// Directions of the children
+// of a concurrent tree node.
+const (
+	dirLeft  = 0
+	dirRight = 1
+)
+
+// Version bits of a concurrent tree node.
+const (
+	// versionUnlinked is the whole version
+	// of a node removed from the tree.
+	versionUnlinked uint64 = 1
+	// versionChanging is set while the
+	// node takes part in a rotation.
+	versionChanging uint64 = 2
+	// versionIncrement is added to the
+	// version after each rotation.
+	versionIncrement uint64 = 4
+)
+
+// Results of the node condition check
+// (non-negative results are new heights).
+const (
+	conditionUnlinkRequired    = -1
+	conditionRebalanceRequired = -2
+	conditionNothingRequired   = -3
+)
+
+// ConcurrentAVLTree is an AVL tree supporting concurrent Add,
+// Remove and Search from many goroutines. It follows the
+// optimistic algorithm of Bronson et al. "A Practical Concurrent
+// Binary Search Tree": readers take no locks and validate the
+// nodes they pass by their version numbers hand-over-hand,
+// writers lock only the nodes they change. Removing a node with
+// two children turns it into a routing node which is unlinked
+// later, and the balance is restored by the writers right after
+// their changes. The zero value is an empty tree ready to use.
+// The tree must not be copied after the first use.
+type ConcurrentAVLTree[TKey constraints.Ordered, TValue any] struct {
+	// holder is a sentinel node whose
+	// right child is the root of the tree.
+	holder concurrentNode[TKey, TValue]
+	size   atomic.Int64
+}
+
+type concurrentNode[TKey constraints.Ordered, TValue any] struct {
+	key TKey
+	// value is nil for routing nodes.
+	value atomic.Pointer[TValue]
+
+	// height counts nodes (not edges)
+	height   atomic.Int32
+	children [2]atomic.Pointer[concurrentNode[TKey, TValue]]
+	parent   atomic.Pointer[concurrentNode[TKey, TValue]]
+	version  atomic.Uint64
+	// mu is held by the writers
+	// changing the node.
+	mu sync.Mutex
+}
+
+// Search returns the value stored by the key
+// and whether there's such key in the tree.
+func (t *ConcurrentAVLTree[TKey, TValue]) Search(key TKey) (TValue, bool) {
+	var zeroValTValue TValue
+
+	for {
+		root := t.holder.children[dirRight].Load()
+
+		if root == nil {
+			return zeroValTValue, false
+		}
+
+		dir, found := direction(key, root.key)
+
+		if found {
+			return loadValue(root)
+		}
+
+		version := root.version.Load()
+
+		if isChangingOrUnlinked(version) {
+			root.waitUntilChanged()
+			continue
+		}
+
+		if root != t.holder.children[dirRight].Load() {
+			continue
+		}
+
+		value, ok := t.attemptGet(key, root, dir, version)
+
+		if ok {
+			if value == nil {
+				return zeroValTValue, false
+			}
+
+			return *value, true
+		}
+	}
+}
+
+// Add sets the value by the key
+// inserting the key if it's absent.
+func (t *ConcurrentAVLTree[TKey, TValue]) Add(key TKey, value TValue) {
+	t.update(key, &value)
+}
+
+// Remove removes the key from the tree. Reports
+// whether there has been such key in the tree.
+func (t *ConcurrentAVLTree[TKey, TValue]) Remove(key TKey) bool {
+	return t.update(key, nil) != nil
+}
+
+// Len returns the number of keys in the tree.
+func (t *ConcurrentAVLTree[TKey, TValue]) Len() int {
+	return int(t.size.Load())
+}
+
+// Sets the value by the key or removes the key if the value
+// is nil. Returns the previous value or nil if there's been none
+func (t *ConcurrentAVLTree[TKey, TValue]) update(key TKey, value *TValue) *TValue {
+	holder := &t.holder
+
+	for {
+		root := holder.children[dirRight].Load()
+
+		if root == nil {
+			if value == nil || t.attemptInsertIntoEmpty(key, value) {
+				return nil
+			}
+
+			continue
+		}
+
+		version := root.version.Load()
+
+		if isChangingOrUnlinked(version) {
+			root.waitUntilChanged()
+			continue
+		}
+
+		if root != holder.children[dirRight].Load() {
+			continue
+		}
+
+		prev, ok := t.attemptUpdate(key, value, holder, root, version)
+
+		if !ok {
+			continue
+		}
+
+		if value != nil && prev == nil {
+			t.size.Add(1)
+		} else if value == nil && prev != nil {
+			t.size.Add(-1)
+		}
+
+		return prev
+	}
+}
+
+// Looks for the key in the subtree of the child of the node in
+// the direction. Returns false if the node has changed since its
+// version has been read, so the caller must retry
+func (t *ConcurrentAVLTree[TKey, TValue]) attemptGet(
+	key TKey, node *concurrentNode[TKey, TValue],
+	dir int, nodeVersion uint64,
+) (*TValue, bool) {
+	for {
+		child := node.children[dir].Load()
+
+		if child == nil {
+			if node.version.Load() != nodeVersion {
+				return nil, false
+			}
+
+			return nil, true
+		}
+
+		childDir, found := direction(key, child.key)
+
+		if found {
+			return child.value.Load(), true
+		}
+
+		childVersion := child.version.Load()
+
+		if isChangingOrUnlinked(childVersion) {
+			child.waitUntilChanged()
+
+			if node.version.Load() != nodeVersion {
+				return nil, false
+			}
+
+			continue
+		}
+
+		if child != node.children[dir].Load() {
+			if node.version.Load() != nodeVersion {
+				return nil, false
+			}
+
+			continue
+		}
+
+		// the traversal from the
+		// parent to the node is valid
+		if node.version.Load() != nodeVersion {
+			return nil, false
+		}
+
+		value, ok := t.attemptGet(key, child, childDir, childVersion)
+
+		if ok {
+			return value, true
+		}
+	}
+}
+
+// Makes the new node the root of the empty tree
+func (t *ConcurrentAVLTree[TKey, TValue]) attemptInsertIntoEmpty(key TKey, value *TValue) bool {
+	holder := &t.holder
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+
+	if holder.children[dirRight].Load() != nil {
+		return false
+	}
+
+	holder.children[dirRight].Store(newConcurrentNode(key, value, holder))
+	holder.height.Store(2)
+	t.size.Add(1)
+
+	return true
+}
+
+// Updates the key in the subtree of the node. Returns the
+// previous value and false if the node has changed since
+// its version has been read, so the caller must retry
+func (t *ConcurrentAVLTree[TKey, TValue]) attemptUpdate(
+	key TKey, value *TValue,
+	parent, node *concurrentNode[TKey, TValue],
+	nodeVersion uint64,
+) (*TValue, bool) {
+	dir, found := direction(key, node.key)
+
+	if found {
+		return t.attemptNodeUpdate(value, parent, node)
+	}
+
+	for {
+		child := node.children[dir].Load()
+
+		if node.version.Load() != nodeVersion {
+			return nil, false
+		}
+
+		if child == nil {
+			// the key is absent
+			if value == nil {
+				return nil, true
+			}
+
+			inserted, damaged, ok := t.attemptInsert(key, value, node, dir, nodeVersion)
+
+			if !ok {
+				return nil, false
+			}
+
+			if inserted {
+				t.fixHeightAndRebalance(damaged)
+				return nil, true
+			}
+
+			// lost the race with a
+			// concurrent insertion
+			continue
+		}
+
+		childVersion := child.version.Load()
+
+		if isChangingOrUnlinked(childVersion) {
+			child.waitUntilChanged()
+			continue
+		}
+
+		if child != node.children[dir].Load() {
+			continue
+		}
+
+		// the traversal from the
+		// parent to the node is valid
+		if node.version.Load() != nodeVersion {
+			return nil, false
+		}
+
+		prev, ok := t.attemptUpdate(key, value, node, child, childVersion)
+
+		if ok {
+			return prev, true
+		}
+	}
+}
+
+// Attaches a new leaf to the node in the direction. Reports
+// whether the leaf has been attached and returns the damaged
+// node. Returns false if the node has changed since its version
+// has been read, so the caller must retry
+func (t *ConcurrentAVLTree[TKey, TValue]) attemptInsert(
+	key TKey, value *TValue,
+	node *concurrentNode[TKey, TValue],
+	dir int, nodeVersion uint64,
+) (bool, *concurrentNode[TKey, TValue], bool) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	// no rotations can happen
+	// while the node is locked
+	if node.version.Load() != nodeVersion {
+		return false, nil, false
+	}
+
+	if node.children[dir].Load() != nil {
+		return false, nil, true
+	}
+
+	node.children[dir].Store(newConcurrentNode(key, value, node))
+
+	return true, t.fixHeight(node), true
+}
+
+// Updates the value of the node with the key. Returns
+// the previous value and false if the node has been
+// unlinked or moved, so the caller must retry
+func (t *ConcurrentAVLTree[TKey, TValue]) attemptNodeUpdate(
+	value *TValue,
+	parent, node *concurrentNode[TKey, TValue],
+) (*TValue, bool) {
+	if value == nil && node.value.Load() == nil {
+		// already removed
+		return nil, true
+	}
+
+	if value == nil && (node.children[dirLeft].Load() == nil || node.children[dirRight].Load() == nil) {
+		// the node can be unlinked,
+		// so the parent is locked too
+		prev, damaged, ok := t.attemptRemoveNode(parent, node)
+
+		if ok {
+			t.fixHeightAndRebalance(damaged)
+		}
+
+		return prev, ok
+	}
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	if node.version.Load() == versionUnlinked {
+		return nil, false
+	}
+
+	// retry if the node can be unlinked now
+	if value == nil && (node.children[dirLeft].Load() == nil || node.children[dirRight].Load() == nil) {
+		return nil, false
+	}
+
+	// the node with two children
+	// turns into a routing node
+	return node.value.Swap(value), true
+}
+
+// Unlinks the node with at most one child. Returns the previous
+// value and the damaged node. Returns false if the node has been
+// moved or got the second child, so the caller must retry
+func (t *ConcurrentAVLTree[TKey, TValue]) attemptRemoveNode(
+	parent, node *concurrentNode[TKey, TValue],
+) (*TValue, *concurrentNode[TKey, TValue], bool) {
+	parent.mu.Lock()
+	defer parent.mu.Unlock()
+
+	if parent.version.Load() == versionUnlinked || node.parent.Load() != parent {
+		return nil, nil, false
+	}
+
+	node.mu.Lock()
+	prev := node.value.Load()
+
+	if prev == nil {
+		node.mu.Unlock()
+		return nil, nil, true
+	}
+
+	unlinked := t.attemptUnlink(parent, node)
+	node.mu.Unlock()
+
+	if !unlinked {
+		return nil, nil, false
+	}
+
+	return prev, t.fixHeight(parent), true
+}
+
+// Splices out the node with at most one child.
+// Both nodes must be locked. Returns false if
+// the node can't be unlinked anymore
+func (t *ConcurrentAVLTree[TKey, TValue]) attemptUnlink(parent, node *concurrentNode[TKey, TValue]) bool {
+	dir := dirLeft
+
+	if parent.children[dirLeft].Load() != node {
+		if parent.children[dirRight].Load() != node {
+			// the node is no longer
+			// a child of the parent
+			return false
+		}
+
+		dir = dirRight
+	}
+
+	left, right := node.children[dirLeft].Load(), node.children[dirRight].Load()
+
+	if left != nil && right != nil {
+		return false
+	}
+
+	splice := left
+
+	if splice == nil {
+		splice = right
+	}
+
+	parent.children[dir].Store(splice)
+
+	if splice != nil {
+		splice.parent.Store(parent)
+	}
+
+	node.version.Store(versionUnlinked)
+	node.value.Store(nil)
+
+	return true
+}
+
+// Tells what the node needs: unlinking,
+// rebalancing, a new height or nothing
+func (t *ConcurrentAVLTree[TKey, TValue]) nodeCondition(node *concurrentNode[TKey, TValue]) int {
+	left, right := node.children[dirLeft].Load(), node.children[dirRight].Load()
+
+	if (left == nil || right == nil) && node.value.Load() == nil {
+		return conditionUnlinkRequired
+	}
+
+	// the fields may be changed by other
+	// writers, so the result is a hint
+	height := int(node.height.Load())
+	leftHeight, rightHeight := concurrentHeight(left), concurrentHeight(right)
+	newHeight := 1 + maxElem(leftHeight, rightHeight)
+
+	if balance := leftHeight - rightHeight; balance < -1 || balance > 1 {
+		return conditionRebalanceRequired
+	}
+
+	if height != newHeight {
+		return newHeight
+	}
+
+	return conditionNothingRequired
+}
+
+// Repairs the damaged node and walks up to the root repairing
+// its ancestors. The walk doesn't stop at the first undamaged
+// node because a rotation may leave both a deeper node and the
+// ancestors of the rotated subtree damaged. Stops at the nodes
+// unlinked by other writers as they repair the ancestors then
+func (t *ConcurrentAVLTree[TKey, TValue]) fixHeightAndRebalance(node *concurrentNode[TKey, TValue]) {
+	for node != nil && node.parent.Load() != nil {
+		condition := t.nodeCondition(node)
+
+		if node.version.Load() == versionUnlinked {
+			return
+		}
+
+		if condition == conditionNothingRequired {
+			node = node.parent.Load()
+			continue
+		}
+
+		if condition != conditionUnlinkRequired && condition != conditionRebalanceRequired {
+			locked := node
+			locked.mu.Lock()
+			node = t.fixHeight(locked)
+
+			if node == nil {
+				node = locked.parent.Load()
+			}
+
+			locked.mu.Unlock()
+
+			continue
+		}
+
+		parent := node.parent.Load()
+		parent.mu.Lock()
+
+		if parent.version.Load() != versionUnlinked && node.parent.Load() == parent {
+			locked := node
+			locked.mu.Lock()
+			node = t.rebalance(parent, locked)
+			locked.mu.Unlock()
+
+			if node == nil {
+				node = parent
+			}
+		}
+
+		parent.mu.Unlock()
+	}
+}
+
+// Fixes the height of the locked node. Returns the
+// lowest damaged node this writer is responsible for
+// or nil if nothing has to be repaired
+func (t *ConcurrentAVLTree[TKey, TValue]) fixHeight(node *concurrentNode[TKey, TValue]) *concurrentNode[TKey, TValue] {
+	condition := t.nodeCondition(node)
+
+	switch condition {
+	case conditionUnlinkRequired, conditionRebalanceRequired:
+		return node
+
+	case conditionNothingRequired:
+		return nil
+
+	default:
+		node.height.Store(int32(condition))
+		return node.parent.Load()
+	}
+}
+
+// Restores the balance of the locked node having the locked
+// parent. Returns the damaged node or nil if nothing else
+// has to be repaired
+func (t *ConcurrentAVLTree[TKey, TValue]) rebalance(parent, node *concurrentNode[TKey, TValue]) *concurrentNode[TKey, TValue] {
+	left, right := node.children[dirLeft].Load(), node.children[dirRight].Load()
+
+	if (left == nil || right == nil) && node.value.Load() == nil {
+		if t.attemptUnlink(parent, node) {
+			return t.fixHeight(parent)
+		}
+
+		return node
+	}
+
+	height := int(node.height.Load())
+	leftHeight, rightHeight := concurrentHeight(left), concurrentHeight(right)
+	newHeight := 1 + maxElem(leftHeight, rightHeight)
+	balance := leftHeight - rightHeight
+
+	if balance > 1 {
+		return t.rebalanceTo(parent, node, left, rightHeight, dirRight)
+	}
+
+	if balance < -1 {
+		return t.rebalanceTo(parent, node, right, leftHeight, dirLeft)
+	}
+
+	if height != newHeight {
+		node.height.Store(int32(newHeight))
+		return t.fixHeight(parent)
+	}
+
+	return nil
+}
+
+// Rotates the locked node in the direction because its
+// child on the opposite side is too tall. The child of
+// the node in the direction has the specified height
+func (t *ConcurrentAVLTree[TKey, TValue]) rebalanceTo(
+	parent, node, child *concurrentNode[TKey, TValue],
+	height, dir int,
+) *concurrentNode[TKey, TValue] {
+	child.mu.Lock()
+	defer child.mu.Unlock()
+
+	if int(child.height.Load())-height <= 1 {
+		return node
+	}
+
+	// the grandchild is on the inner side
+	// and the outer one is its sibling
+	inner := child.children[dir].Load()
+	outerHeight := concurrentHeight(child.children[1-dir].Load())
+	innerHeight := concurrentHeight(inner)
+
+	if outerHeight >= innerHeight {
+		return t.rotate(parent, node, child, height, outerHeight, inner, innerHeight, dir)
+	}
+
+	inner.mu.Lock()
+	innerHeight = int(inner.height.Load())
+
+	if outerHeight >= innerHeight {
+		damaged := t.rotate(parent, node, child, height, outerHeight, inner, innerHeight, dir)
+		inner.mu.Unlock()
+
+		return damaged
+	}
+
+	innerOuterHeight := concurrentHeight(inner.children[1-dir].Load())
+	innerInnerHeight := concurrentHeight(inner.children[dir].Load())
+	balance := outerHeight - innerOuterHeight
+	childRouting := (outerHeight == 0 || innerOuterHeight == 0) && child.value.Load() == nil
+	nodeDamaged := innerInnerHeight-height < -1 || innerInnerHeight-height > 1 ||
+		(innerInnerHeight == 0 || height == 0) && node.value.Load() == nil
+
+	// the double rotation is only done if it leaves
+	// the child balanced and damages at most one
+	// of the node and the child, so the damaged
+	// nodes stay on a single path to the root
+	if balance >= -1 && balance <= 1 && !(childRouting && nodeDamaged) {
+		damaged := t.rotateOver(parent, node, child, height, outerHeight, inner, innerOuterHeight, dir)
+		inner.mu.Unlock()
+
+		return damaged
+	}
+
+	inner.mu.Unlock()
+
+	// rebalance the child first, the node
+	// is rebalanced later if necessary
+	return t.rebalanceTo(node, child, inner, outerHeight, 1-dir)
+}
+
+// Rotates the node in the direction making its child
+// on the opposite side take its place. The parent, the
+// node and the child must be locked. Returns the damaged
+// node or nil if nothing else has to be repaired
+func (t *ConcurrentAVLTree[TKey, TValue]) rotate(
+	parent, node, child *concurrentNode[TKey, TValue],
+	height, outerHeight int,
+	inner *concurrentNode[TKey, TValue], innerHeight int,
+	dir int,
+) *concurrentNode[TKey, TValue] {
+	nodeVersion := node.version.Load()
+	childVersion := child.version.Load()
+	parentDir := parent.childDirection(node)
+
+	node.version.Store(nodeVersion | versionChanging)
+	child.version.Store(childVersion | versionChanging)
+
+	// the links from the changing nodes go
+	// first and the links to them go last so
+	// readers can't bypass the version checks
+	node.children[1-dir].Store(inner)
+
+	if inner != nil {
+		inner.parent.Store(node)
+	}
+
+	child.children[dir].Store(node)
+	node.parent.Store(child)
+	parent.children[parentDir].Store(child)
+	child.parent.Store(parent)
+
+	newHeight := 1 + maxElem(innerHeight, height)
+	node.height.Store(int32(newHeight))
+	child.height.Store(int32(1 + maxElem(outerHeight, newHeight)))
+
+	child.version.Store(childVersion + versionIncrement)
+	node.version.Store(nodeVersion + versionIncrement)
+
+	// the node is the deepest
+	// of the damaged nodes
+	if balance := innerHeight - height; balance < -1 || balance > 1 {
+		return node
+	}
+
+	if (inner == nil || height == 0) && node.value.Load() == nil {
+		return node
+	}
+
+	if balance := outerHeight - newHeight; balance < -1 || balance > 1 {
+		return child
+	}
+
+	if outerHeight == 0 && child.value.Load() == nil {
+		return child
+	}
+
+	return t.fixHeight(parent)
+}
+
+// Rotates the child in the opposite direction and
+// then the node in the direction making the inner
+// grandchild take the place of the node. All four
+// nodes must be locked. Returns the damaged node
+// or nil if nothing else has to be repaired
+func (t *ConcurrentAVLTree[TKey, TValue]) rotateOver(
+	parent, node, child *concurrentNode[TKey, TValue],
+	height, outerHeight int,
+	inner *concurrentNode[TKey, TValue], innerOuterHeight int,
+	dir int,
+) *concurrentNode[TKey, TValue] {
+	nodeVersion := node.version.Load()
+	childVersion := child.version.Load()
+	innerVersion := inner.version.Load()
+	parentDir := parent.childDirection(node)
+	innerOuter := inner.children[1-dir].Load()
+	innerInner := inner.children[dir].Load()
+	innerInnerHeight := concurrentHeight(innerInner)
+
+	node.version.Store(nodeVersion | versionChanging)
+	child.version.Store(childVersion | versionChanging)
+	inner.version.Store(innerVersion | versionChanging)
+
+	node.children[1-dir].Store(innerInner)
+
+	if innerInner != nil {
+		innerInner.parent.Store(node)
+	}
+
+	child.children[dir].Store(innerOuter)
+
+	if innerOuter != nil {
+		innerOuter.parent.Store(child)
+	}
+
+	inner.children[1-dir].Store(child)
+	child.parent.Store(inner)
+	inner.children[dir].Store(node)
+	node.parent.Store(inner)
+	parent.children[parentDir].Store(inner)
+	inner.parent.Store(parent)
+
+	newHeight := 1 + maxElem(innerInnerHeight, height)
+	node.height.Store(int32(newHeight))
+	newChildHeight := 1 + maxElem(outerHeight, innerOuterHeight)
+	child.height.Store(int32(newChildHeight))
+	inner.height.Store(int32(1 + maxElem(newChildHeight, newHeight)))
+
+	inner.version.Store(innerVersion + versionIncrement)
+	child.version.Store(childVersion + versionIncrement)
+	node.version.Store(nodeVersion + versionIncrement)
+
+	// the node is the deepest
+	// of the damaged nodes
+	if balance := innerInnerHeight - height; balance < -1 || balance > 1 {
+		return node
+	}
+
+	if (innerInner == nil || height == 0) && node.value.Load() == nil {
+		return node
+	}
+
+	if (innerOuter == nil || outerHeight == 0) && child.value.Load() == nil {
+		return child
+	}
+
+	if balance := newChildHeight - newHeight; balance < -1 || balance > 1 {
+		return inner
+	}
+
+	return t.fixHeight(parent)
+}
+
+// Returns the direction of the child
+func (n *concurrentNode[TKey, TValue]) childDirection(child *concurrentNode[TKey, TValue]) int {
+	if n.children[dirLeft].Load() == child {
+		return dirLeft
+	}
+
+	return dirRight
+}
+
+// Waits until the writer changing
+// the node releases its lock
+func (n *concurrentNode[TKey, TValue]) waitUntilChanged() {
+	n.mu.Lock()
+	n.mu.Unlock()
+}
+
+func newConcurrentNode[
+	TKey constraints.Ordered, TValue any,
+](
+	key TKey, value *TValue,
+	parent *concurrentNode[TKey, TValue],
+) *concurrentNode[TKey, TValue] {
+	node := &concurrentNode[TKey, TValue]{
+		key: key,
+	}
+	node.value.Store(value)
+	node.height.Store(1)
+	node.parent.Store(parent)
+
+	return node
+}
+
+// Returns the direction from the node
+// key to the key and whether they're equal
+func direction[TKey constraints.Ordered](key, nodeKey TKey) (int, bool) {
+	if key < nodeKey {
+		return dirLeft, false
+	}
+
+	if key > nodeKey {
+		return dirRight, false
+	}
+
+	return dirRight, true
+}
+
+func loadValue[
+	TKey constraints.Ordered, TValue any,
+](
+	node *concurrentNode[TKey, TValue],
+) (TValue, bool) {
+	var zeroValTValue TValue
+	value := node.value.Load()
+
+	if value == nil {
+		return zeroValTValue, false
+	}
+
+	return *value, true
+}
+
+func concurrentHeight[
+	TKey constraints.Ordered, TValue any,
+](
+	node *concurrentNode[TKey, TValue],
+) int {
+	if node == nil {
+		return 0
+	}
+
+	return int(node.height.Load())
+}
+
+func isChangingOrUnlinked(version uint64) bool {
+	return version&(versionChanging|versionUnlinked) != 0
+}
@@ -0,0 +1,119 @@
+package avltree_test
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zergon321/go-avltree"
+)
+
+func TestConcurrentAVLTree(t *testing.T) {
+	tree := &avltree.ConcurrentAVLTree[int, int]{}
+	ref := map[int]int{}
+
+	for i := 0; i < 5000; i++ {
+		key := rand.Intn(500)
+
+		if rand.Intn(3) == 0 {
+			_, existed := ref[key]
+			assert.Equal(t, existed, tree.Remove(key))
+			delete(ref, key)
+		} else {
+			tree.Add(key, i)
+			ref[key] = i
+		}
+
+		if err := tree.Validate(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+
+	assert.Equal(t, len(ref), tree.Len())
+
+	for key := 0; key < 500; key++ {
+		value, ok := tree.Search(key)
+		refValue, refOk := ref[key]
+
+		assert.Equal(t, refOk, ok)
+		assert.Equal(t, refValue, value)
+	}
+}
+
+func TestConcurrentAVLTreeStress(t *testing.T) {
+	const (
+		writers    = 8
+		operations = 20000
+		keys       = 256
+	)
+
+	tree := &avltree.ConcurrentAVLTree[int, int]{}
+	wg := &sync.WaitGroup{}
+
+	// each writer owns the keys equal to
+	// its index modulo the number of writers
+	finals := make([]map[int]int, writers)
+
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		finals[w] = map[int]int{}
+
+		go func(w int) {
+			defer wg.Done()
+
+			rnd := rand.New(rand.NewSource(int64(w)))
+			final := finals[w]
+
+			for i := 0; i < operations; i++ {
+				key := rnd.Intn(keys)*writers + w
+
+				switch rnd.Intn(3) {
+				case 0:
+					_, existed := final[key]
+
+					if tree.Remove(key) != existed {
+						t.Errorf("Remove(%d) reported a wrong result", key)
+						return
+					}
+
+					delete(final, key)
+
+				case 1:
+					tree.Add(key, i)
+					final[key] = i
+
+				case 2:
+					value, ok := tree.Search(key)
+					expected, existed := final[key]
+
+					if ok != existed || value != expected {
+						t.Errorf("Search(%d) = (%d, %t), expected (%d, %t)",
+							key, value, ok, expected, existed)
+						return
+					}
+				}
+
+				// readers of the foreign keys
+				tree.Search(rnd.Intn(keys * writers))
+			}
+		}(w)
+	}
+
+	wg.Wait()
+	assert.Nil(t, tree.Validate())
+
+	count := 0
+
+	for _, final := range finals {
+		for key, expected := range final {
+			value, ok := tree.Search(key)
+			assert.True(t, ok)
+			assert.Equal(t, expected, value)
+		}
+
+		count += len(final)
+	}
+
+	assert.Equal(t, count, tree.Len())
+}
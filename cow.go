@@ -0,0 +1,411 @@
+package avltree
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/exp/constraints"
+)
+
+// COWAVLTree is an AVL tree for read-mostly workloads.
+// Writers copy the path from the root to the changed node
+// and publish the new root atomically, so readers never
+// take locks and always see a consistent snapshot. Writers
+// are serialized by a mutex. The nodes are shared between
+// the snapshots, so they're never returned to a pool.
+type COWAVLTree[TKey constraints.Ordered, TValue any] struct {
+	current atomic.Pointer[COWAVLSnapshot[TKey, TValue]]
+	writer  sync.Mutex
+}
+
+// COWAVLSnapshot is an immutable version of the
+// copy-on-write tree. It stays valid and unchanged
+// no matter what is written to the tree later.
+type COWAVLSnapshot[TKey constraints.Ordered, TValue any] struct {
+	root *COWAVLNode[TKey, TValue]
+	size int
+}
+
+// COWAVLNode is an immutable node
+// of the copy-on-write tree.
+type COWAVLNode[TKey constraints.Ordered, TValue any] struct {
+	key   TKey
+	value TValue
+
+	// height counts nodes (not edges)
+	height int
+	left   *COWAVLNode[TKey, TValue]
+	right  *COWAVLNode[TKey, TValue]
+}
+
+// Key returns the key of the node.
+func (node *COWAVLNode[TKey, TValue]) Key() TKey {
+	return node.key
+}
+
+// Value returns the value of the node.
+func (node *COWAVLNode[TKey, TValue]) Value() TValue {
+	return node.value
+}
+
+// Snapshot returns the current version of the tree.
+// Use it to make several reads see the same version.
+func (t *COWAVLTree[TKey, TValue]) Snapshot() *COWAVLSnapshot[TKey, TValue] {
+	snapshot := t.current.Load()
+
+	if snapshot == nil {
+		return &COWAVLSnapshot[TKey, TValue]{}
+	}
+
+	return snapshot
+}
+
+// Search returns the node with the key in the current
+// version or nil if there's no such key in it.
+func (t *COWAVLTree[TKey, TValue]) Search(key TKey) *COWAVLNode[TKey, TValue] {
+	return t.Snapshot().Search(key)
+}
+
+// VisitInOrder visits the nodes of the current
+// version in the ascending order of keys.
+func (t *COWAVLTree[TKey, TValue]) VisitInOrder(visit func(node *COWAVLNode[TKey, TValue]) error) error {
+	return t.Snapshot().VisitInOrder(visit)
+}
+
+// VisitRange visits the nodes of the current version
+// with lo <= key <= hi in the ascending order of keys.
+func (t *COWAVLTree[TKey, TValue]) VisitRange(lo, hi TKey, visit func(node *COWAVLNode[TKey, TValue]) error) error {
+	return t.Snapshot().VisitRange(lo, hi, visit)
+}
+
+// Len returns the number of
+// nodes in the current version.
+func (t *COWAVLTree[TKey, TValue]) Len() int {
+	return t.Snapshot().Len()
+}
+
+func (t *COWAVLTree[TKey, TValue]) Add(key TKey, value TValue) {
+	t.writer.Lock()
+	defer t.writer.Unlock()
+
+	snapshot := t.Snapshot()
+	root, added := snapshot.root.insert(key, value)
+	t.publish(root, snapshot.size, added)
+}
+
+// AddOrUpdate adds the value by the key if there's no such key
+// in the tree. Otherwise it sets the value returned by upd for
+// the current value. If upd fails, the tree stays unchanged.
+// upd must not write to the tree.
+func (t *COWAVLTree[TKey, TValue]) AddOrUpdate(
+	key TKey, value TValue,
+	upd func(oldValue TValue) (TValue, error),
+) error {
+	t.writer.Lock()
+	defer t.writer.Unlock()
+
+	snapshot := t.Snapshot()
+
+	if node := snapshot.root.search(key); node != nil {
+		var err error
+		value, err = upd(node.value)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	root, added := snapshot.root.insert(key, value)
+	t.publish(root, snapshot.size, added)
+
+	return nil
+}
+
+func (t *COWAVLTree[TKey, TValue]) Remove(key TKey) {
+	t.writer.Lock()
+	defer t.writer.Unlock()
+
+	snapshot := t.Snapshot()
+	root, removed := snapshot.root.remove(key)
+
+	if removed {
+		t.publish(root, snapshot.size-1, false)
+	}
+}
+
+// Clear publishes an empty version of the tree.
+func (t *COWAVLTree[TKey, TValue]) Clear() {
+	t.writer.Lock()
+	defer t.writer.Unlock()
+
+	t.current.Store(nil)
+}
+
+// Publishes the new version of the tree
+func (t *COWAVLTree[TKey, TValue]) publish(root *COWAVLNode[TKey, TValue], size int, added bool) {
+	if added {
+		size++
+	}
+
+	t.current.Store(&COWAVLSnapshot[TKey, TValue]{
+		root: root,
+		size: size,
+	})
+}
+
+// Search returns the node with the key or
+// nil if there's no such key in the snapshot.
+func (s *COWAVLSnapshot[TKey, TValue]) Search(key TKey) *COWAVLNode[TKey, TValue] {
+	return s.root.search(key)
+}
+
+// VisitInOrder visits the nodes of the
+// snapshot in the ascending order of keys.
+func (s *COWAVLSnapshot[TKey, TValue]) VisitInOrder(visit func(node *COWAVLNode[TKey, TValue]) error) error {
+	var stack [maxHeight]*COWAVLNode[TKey, TValue]
+	top := 0
+	node := s.root
+
+	for node != nil || top > 0 {
+		for node != nil {
+			stack[top] = node
+			top++
+			node = node.left
+		}
+
+		top--
+		node = stack[top]
+
+		err := visit(node)
+
+		if err != nil {
+			return err
+		}
+
+		node = node.right
+	}
+
+	return nil
+}
+
+// VisitRange visits the nodes of the snapshot with
+// lo <= key <= hi in the ascending order of keys.
+func (s *COWAVLSnapshot[TKey, TValue]) VisitRange(lo, hi TKey, visit func(node *COWAVLNode[TKey, TValue]) error) error {
+	var stack [maxHeight]*COWAVLNode[TKey, TValue]
+	top := 0
+	node := s.root
+
+	for node != nil || top > 0 {
+		for node != nil {
+			if node.key < lo {
+				// the left subtree is
+				// out of the range
+				node = node.right
+				continue
+			}
+
+			stack[top] = node
+			top++
+			node = node.left
+		}
+
+		if top <= 0 {
+			break
+		}
+
+		top--
+		node = stack[top]
+
+		if node.key > hi {
+			return nil
+		}
+
+		err := visit(node)
+
+		if err != nil {
+			return err
+		}
+
+		node = node.right
+	}
+
+	return nil
+}
+
+// Len returns the number
+// of nodes in the snapshot.
+func (s *COWAVLSnapshot[TKey, TValue]) Len() int {
+	return s.size
+}
+
+// Searches for a node
+func (n *COWAVLNode[TKey, TValue]) search(key TKey) *COWAVLNode[TKey, TValue] {
+	for n != nil {
+		if key < n.key {
+			n = n.left
+		} else if key > n.key {
+			n = n.right
+		} else {
+			return n
+		}
+	}
+
+	return nil
+}
+
+// Returns a copy of the subtree with the key set
+// to the value. The nodes on the path are copied,
+// the rest are shared with the original subtree.
+// Reports whether the key has been added
+func (n *COWAVLNode[TKey, TValue]) insert(key TKey, value TValue) (*COWAVLNode[TKey, TValue], bool) {
+	if n == nil {
+		return &COWAVLNode[TKey, TValue]{
+			key:    key,
+			value:  value,
+			height: 1,
+		}, true
+	}
+
+	node := n.copy()
+	added := false
+
+	if key < n.key {
+		node.left, added = n.left.insert(key, value)
+	} else if key > n.key {
+		node.right, added = n.right.insert(key, value)
+	} else {
+		node.value = value
+		return node, false
+	}
+
+	return node.rebalance(), added
+}
+
+// Returns a copy of the subtree without the key.
+// Reports whether the key has been removed
+func (n *COWAVLNode[TKey, TValue]) remove(key TKey) (*COWAVLNode[TKey, TValue], bool) {
+	if n == nil {
+		return nil, false
+	}
+
+	var node *COWAVLNode[TKey, TValue]
+
+	if key < n.key {
+		left, removed := n.left.remove(key)
+
+		if !removed {
+			return n, false
+		}
+
+		node = n.copy()
+		node.left = left
+	} else if key > n.key {
+		right, removed := n.right.remove(key)
+
+		if !removed {
+			return n, false
+		}
+
+		node = n.copy()
+		node.right = right
+	} else {
+		if n.left == nil {
+			return n.right, true
+		}
+
+		if n.right == nil {
+			return n.left, true
+		}
+
+		// replace the node with a copy of the
+		// smallest node of the right sub-tree
+		right, smallest := n.right.removeSmallest()
+		node = smallest.copy()
+		node.left = n.left
+		node.right = right
+	}
+
+	return node.rebalance(), true
+}
+
+// Returns a copy of the subtree without
+// its smallest node and that node
+func (n *COWAVLNode[TKey, TValue]) removeSmallest() (*COWAVLNode[TKey, TValue], *COWAVLNode[TKey, TValue]) {
+	if n.left == nil {
+		return n.right, n
+	}
+
+	left, smallest := n.left.removeSmallest()
+	node := n.copy()
+	node.left = left
+
+	return node.rebalance(), smallest
+}
+
+func (n *COWAVLNode[TKey, TValue]) copy() *COWAVLNode[TKey, TValue] {
+	node := *n
+	return &node
+}
+
+func (n *COWAVLNode[TKey, TValue]) getHeight() int {
+	if n == nil {
+		return 0
+	}
+	return n.height
+}
+
+func (n *COWAVLNode[TKey, TValue]) recalculateHeight() {
+	n.height = 1 + maxElem(n.left.getHeight(), n.right.getHeight())
+}
+
+// Checks if the node is balanced and rebalances it.
+// The node must be a fresh copy not visible to
+// the readers, its children may be shared
+func (n *COWAVLNode[TKey, TValue]) rebalance() *COWAVLNode[TKey, TValue] {
+	n.recalculateHeight()
+
+	// check balance factor and rotateLeft if right-heavy and rotateRight if left-heavy
+	balanceFactor := n.left.getHeight() - n.right.getHeight()
+	if balanceFactor == -2 {
+		// check if child is left-heavy and rotateRight first
+		if n.right.left.getHeight() > n.right.right.getHeight() {
+			n.right = n.right.copy().rotateRight()
+		}
+		return n.rotateLeft()
+	} else if balanceFactor == 2 {
+		// check if child is right-heavy and rotateLeft first
+		if n.left.right.getHeight() > n.left.left.getHeight() {
+			n.left = n.left.copy().rotateLeft()
+		}
+		return n.rotateRight()
+	}
+	return n
+}
+
+// Rotate nodes left to balance the fresh node
+func (n *COWAVLNode[TKey, TValue]) rotateLeft() *COWAVLNode[TKey, TValue] {
+	newRoot := n.right.copy()
+	n.right = newRoot.left
+	newRoot.left = n
+
+	n.recalculateHeight()
+	newRoot.recalculateHeight()
+	return newRoot
+}
+
+// Rotate nodes right to balance the fresh node
+func (n *COWAVLNode[TKey, TValue]) rotateRight() *COWAVLNode[TKey, TValue] {
+	newRoot := n.left.copy()
+	n.left = newRoot.right
+	newRoot.right = n
+
+	n.recalculateHeight()
+	newRoot.recalculateHeight()
+	return newRoot
+}
+
+// NewCOWAVLTree creates a new
+// empty copy-on-write AVL tree.
+func NewCOWAVLTree[TKey constraints.Ordered, TValue any]() *COWAVLTree[TKey, TValue] {
+	return &COWAVLTree[TKey, TValue]{}
+}
@@ -0,0 +1,153 @@
+package avltree_test
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zergon321/go-avltree"
+)
+
+func TestCOWAVLTree(t *testing.T) {
+	tree := avltree.NewCOWAVLTree[int, int]()
+	ref := map[int]int{}
+
+	for i := 0; i < 2000; i++ {
+		key := rand.Intn(300)
+
+		switch rand.Intn(3) {
+		case 0:
+			tree.Add(key, i)
+			ref[key] = i
+
+		case 1:
+			err := tree.AddOrUpdate(key, i, func(oldValue int) (int, error) {
+				return oldValue + i, nil
+			})
+			assert.Nil(t, err)
+			ref[key] += i
+
+		case 2:
+			tree.Remove(key)
+			delete(ref, key)
+		}
+
+		assert.Nil(t, tree.Validate())
+	}
+
+	assert.Equal(t, len(ref), tree.Len())
+
+	for key, value := range ref {
+		node := tree.Search(key)
+		assert.NotNil(t, node)
+		assert.Equal(t, value, node.Value())
+	}
+
+	err := tree.AddOrUpdate(0, 0, func(oldValue int) (int, error) {
+		return 0, errors.New("failed")
+	})
+
+	if _, ok := ref[0]; ok {
+		assert.NotNil(t, err)
+		assert.Equal(t, ref[0], tree.Search(0).Value())
+	} else {
+		assert.Nil(t, err)
+	}
+}
+
+func TestCOWSnapshot(t *testing.T) {
+	tree := avltree.NewCOWAVLTree[int, int]()
+
+	for i := 0; i < 100; i++ {
+		tree.Add(i, i)
+	}
+
+	snapshot := tree.Snapshot()
+
+	for i := 0; i < 100; i += 2 {
+		tree.Remove(i)
+	}
+
+	tree.Add(1, -1)
+
+	assert.Nil(t, snapshot.Validate())
+	assert.Equal(t, 100, snapshot.Len())
+	assert.Equal(t, 1, snapshot.Search(1).Value())
+	assert.Equal(t, 50, tree.Len())
+	assert.Equal(t, -1, tree.Search(1).Value())
+
+	keys := []int{}
+	err := snapshot.VisitRange(10, 14, func(node *avltree.COWAVLNode[int, int]) error {
+		keys = append(keys, node.Key())
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{10, 11, 12, 13, 14}, keys)
+
+	keys = keys[:0]
+	err = tree.VisitRange(10, 14, func(node *avltree.COWAVLNode[int, int]) error {
+		keys = append(keys, node.Key())
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{11, 13}, keys)
+}
+
+func TestCOWConcurrentReaders(t *testing.T) {
+	tree := avltree.NewCOWAVLTree[int, int]()
+	wg := &sync.WaitGroup{}
+	done := make(chan struct{})
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				snapshot := tree.Snapshot()
+				count := 0
+				prev := -1
+
+				err := snapshot.VisitInOrder(func(node *avltree.COWAVLNode[int, int]) error {
+					if node.Key() <= prev || node.Value() != node.Key() {
+						return errors.New("inconsistent snapshot")
+					}
+
+					prev = node.Key()
+					count++
+
+					return nil
+				})
+
+				if err != nil || count != snapshot.Len() {
+					t.Error("inconsistent snapshot")
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 5000; i++ {
+		key := rand.Intn(500)
+
+		if rand.Intn(3) == 0 {
+			tree.Remove(key)
+		} else {
+			tree.Add(key, key)
+		}
+	}
+
+	close(done)
+	wg.Wait()
+
+	assert.Nil(t, tree.Validate())
+}
@@ -0,0 +1,326 @@
+package avltree
+
+import "golang.org/x/exp/constraints"
+
+// DiffKind tells how an entry
+// differs between two trees.
+type DiffKind int
+
+const (
+	// DiffAdded means the entry is
+	// only present in the new tree.
+	DiffAdded DiffKind = iota
+	// DiffRemoved means the entry is
+	// only present in the old tree.
+	DiffRemoved
+	// DiffChanged means the entry is
+	// present in both trees but
+	// the values aren't equal.
+	DiffChanged
+)
+
+// DiffEntry is a single difference between
+// two trees. OldValue is set for removed and
+// changed entries, NewValue is set for added
+// and changed entries.
+type DiffEntry[TKey any, TValue any] struct {
+	Kind     DiffKind
+	Key      TKey
+	OldValue TValue
+	NewValue TValue
+}
+
+// AVLTreeView is the read-only state of an AVL tree
+// which Diff compares. It's implemented by AVLTree
+// and AVLSnapshot.
+type AVLTreeView[TKey constraints.Ordered, TValue any] interface {
+	rootNode() *AVLNode[TKey, TValue]
+}
+
+func (t *AVLTree[TKey, TValue]) rootNode() *AVLNode[TKey, TValue] {
+	return t.root
+}
+
+func (s *AVLSnapshot[TKey, TValue]) rootNode() *AVLNode[TKey, TValue] {
+	return s.root
+}
+
+// Diff walks both views at once and calls visit
+// for each difference in the ascending order of keys.
+// Subtrees shared by both views, such as those of
+// a snapshot and the tree it's been taken from, are
+// skipped without descending into them. Stops at the
+// first error returned by visit. The trees must not
+// be modified during the walk.
+func Diff[
+	TKey constraints.Ordered, TValue any,
+	TOld AVLTreeView[TKey, TValue], TNew AVLTreeView[TKey, TValue],
+](
+	a TOld, b TNew,
+	valueEq func(x, y TValue) bool,
+	visit func(entry DiffEntry[TKey, TValue]) error,
+) error {
+	var oldIt, newIt avlDiffIterator[TKey, TValue]
+	oldIt.push(a.rootNode(), false)
+	newIt.push(b.rootNode(), false)
+
+	for {
+		oldNode, newNode := oldIt.sync(&newIt)
+
+		if oldNode == nil && newNode == nil {
+			return nil
+		}
+
+		var entry DiffEntry[TKey, TValue]
+
+		switch {
+		case newNode == nil || oldNode != nil && oldNode.key < newNode.key:
+			entry.Kind = DiffRemoved
+			entry.Key = oldNode.key
+			entry.OldValue = oldNode.Value
+			oldIt.pop()
+
+		case oldNode == nil || oldNode.key > newNode.key:
+			entry.Kind = DiffAdded
+			entry.Key = newNode.key
+			entry.NewValue = newNode.Value
+			newIt.pop()
+
+		default:
+			oldIt.pop()
+			newIt.pop()
+
+			if valueEq(oldNode.Value, newNode.Value) {
+				continue
+			}
+
+			entry.Kind = DiffChanged
+			entry.Key = newNode.key
+			entry.OldValue = oldNode.Value
+			entry.NewValue = newNode.Value
+		}
+
+		err := visit(entry)
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// avlDiffIterator is an in-order walk over a tree
+// which keeps unexpanded subtrees on its stack
+// so the subtrees shared by two trees can be
+// skipped as a whole.
+type avlDiffIterator[TKey constraints.Ordered, TValue any] struct {
+	// each level holds at most a pending
+	// right subtree and a node to emit
+	stack [2*maxHeight + 1]avlDiffIteratorItem[TKey, TValue]
+	top   int
+}
+
+type avlDiffIteratorItem[TKey constraints.Ordered, TValue any] struct {
+	node *AVLNode[TKey, TValue]
+	// emit tells the node is to be visited
+	// itself rather than its subtree
+	emit bool
+}
+
+func (it *avlDiffIterator[TKey, TValue]) push(node *AVLNode[TKey, TValue], emit bool) {
+	if node == nil {
+		return
+	}
+
+	it.stack[it.top] = avlDiffIteratorItem[TKey, TValue]{
+		node: node,
+		emit: emit,
+	}
+	it.top++
+}
+
+func (it *avlDiffIterator[TKey, TValue]) pop() {
+	it.top--
+}
+
+// Returns the top of the stack
+// or a nil node if it's empty
+func (it *avlDiffIterator[TKey, TValue]) peek() avlDiffIteratorItem[TKey, TValue] {
+	if it.top <= 0 {
+		return avlDiffIteratorItem[TKey, TValue]{}
+	}
+
+	return it.stack[it.top-1]
+}
+
+// Replaces the subtree on the
+// top with its in-order parts
+func (it *avlDiffIterator[TKey, TValue]) expand() {
+	it.top--
+	node := it.stack[it.top].node
+
+	it.push(node.right, false)
+	it.push(node, true)
+	it.push(node.left, false)
+}
+
+// Expands the subtrees on the tops of both stacks
+// until both of them hold the nodes to emit and
+// returns these nodes. The identical subtrees
+// on the tops are dropped from both stacks
+func (it *avlDiffIterator[TKey, TValue]) sync(other *avlDiffIterator[TKey, TValue]) (*AVLNode[TKey, TValue], *AVLNode[TKey, TValue]) {
+	for {
+		top, otherTop := it.peek(), other.peek()
+
+		switch {
+		case top.node != nil && top.node == otherTop.node && !top.emit && !otherTop.emit:
+			it.pop()
+			other.pop()
+
+		case top.node != nil && !top.emit &&
+			(otherTop.node == nil || otherTop.emit || top.node.height >= otherTop.node.height):
+			it.expand()
+
+		case otherTop.node != nil && !otherTop.emit:
+			other.expand()
+
+		default:
+			return top.node, otherTop.node
+		}
+	}
+}
+
+// DiffUnrestricted walks both trees at once and calls visit
+// for each difference in the ascending order of keys.
+// The trees never share nodes, so all the entries
+// are compared. Stops at the first error returned
+// by visit. The trees must not be modified during
+// the walk.
+func DiffUnrestricted[
+	TKey Comparable, TValue any,
+](
+	a, b *UnrestrictedAVLTree[TKey, TValue],
+	valueEq func(x, y TValue) bool,
+	visit func(entry DiffEntry[TKey, TValue]) error,
+) error {
+	var oldIt, newIt unrestrictedDiffIterator[TKey, TValue]
+	oldIt.push(a.root, false)
+	newIt.push(b.root, false)
+
+	for {
+		oldNode, newNode := oldIt.sync(&newIt)
+
+		if oldNode == nil && newNode == nil {
+			return nil
+		}
+
+		var entry DiffEntry[TKey, TValue]
+
+		switch {
+		case newNode == nil || oldNode != nil && oldNode.key.Less(newNode.key):
+			entry.Kind = DiffRemoved
+			entry.Key = oldNode.key
+			entry.OldValue = oldNode.Value
+			oldIt.pop()
+
+		case oldNode == nil || oldNode.key.Greater(newNode.key):
+			entry.Kind = DiffAdded
+			entry.Key = newNode.key
+			entry.NewValue = newNode.Value
+			newIt.pop()
+
+		default:
+			oldIt.pop()
+			newIt.pop()
+
+			if valueEq(oldNode.Value, newNode.Value) {
+				continue
+			}
+
+			entry.Kind = DiffChanged
+			entry.Key = newNode.key
+			entry.OldValue = oldNode.Value
+			entry.NewValue = newNode.Value
+		}
+
+		err := visit(entry)
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// unrestrictedDiffIterator is an in-order walk
+// over a tree which keeps unexpanded subtrees
+// on its stack.
+type unrestrictedDiffIterator[TKey Comparable, TValue any] struct {
+	// each level holds at most a pending
+	// right subtree and a node to emit
+	stack [2*maxHeight + 1]unrestrictedDiffIteratorItem[TKey, TValue]
+	top   int
+}
+
+type unrestrictedDiffIteratorItem[TKey Comparable, TValue any] struct {
+	node *UnrestrictedAVLNode[TKey, TValue]
+	// emit tells the node is to be visited
+	// itself rather than its subtree
+	emit bool
+}
+
+func (it *unrestrictedDiffIterator[TKey, TValue]) push(node *UnrestrictedAVLNode[TKey, TValue], emit bool) {
+	if node == nil {
+		return
+	}
+
+	it.stack[it.top] = unrestrictedDiffIteratorItem[TKey, TValue]{
+		node: node,
+		emit: emit,
+	}
+	it.top++
+}
+
+func (it *unrestrictedDiffIterator[TKey, TValue]) pop() {
+	it.top--
+}
+
+// Returns the top of the stack
+// or a nil node if it's empty
+func (it *unrestrictedDiffIterator[TKey, TValue]) peek() unrestrictedDiffIteratorItem[TKey, TValue] {
+	if it.top <= 0 {
+		return unrestrictedDiffIteratorItem[TKey, TValue]{}
+	}
+
+	return it.stack[it.top-1]
+}
+
+// Replaces the subtree on the
+// top with its in-order parts
+func (it *unrestrictedDiffIterator[TKey, TValue]) expand() {
+	it.top--
+	node := it.stack[it.top].node
+
+	it.push(node.right, false)
+	it.push(node, true)
+	it.push(node.left, false)
+}
+
+// Expands the subtrees on the tops of both stacks
+// until both of them hold the nodes to emit and
+// returns these nodes
+func (it *unrestrictedDiffIterator[TKey, TValue]) sync(other *unrestrictedDiffIterator[TKey, TValue]) (*UnrestrictedAVLNode[TKey, TValue], *UnrestrictedAVLNode[TKey, TValue]) {
+	for {
+		top, otherTop := it.peek(), other.peek()
+
+		switch {
+		case top.node != nil && !top.emit &&
+			(otherTop.node == nil || otherTop.emit || top.node.height >= otherTop.node.height):
+			it.expand()
+
+		case otherTop.node != nil && !otherTop.emit:
+			other.expand()
+
+		default:
+			return top.node, otherTop.node
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package avltree_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zergon321/go-avltree"
+)
+
+func TestDiff(t *testing.T) {
+	oldTree := &avltree.AVLTree[int, int]{}
+	newTree := &avltree.AVLTree[int, int]{}
+	expected := []avltree.DiffEntry[int, int]{}
+
+	for i := 0; i < 1000; i++ {
+		oldTree.Add(i, i)
+
+		switch rand.Intn(4) {
+		case 0:
+			expected = append(expected, avltree.DiffEntry[int, int]{
+				Kind:     avltree.DiffRemoved,
+				Key:      i,
+				OldValue: i,
+			})
+
+		case 1:
+			newTree.Add(i, i+1)
+			expected = append(expected, avltree.DiffEntry[int, int]{
+				Kind:     avltree.DiffChanged,
+				Key:      i,
+				OldValue: i,
+				NewValue: i + 1,
+			})
+
+		default:
+			newTree.Add(i, i)
+		}
+	}
+
+	for i := 1000; i < 1100; i++ {
+		newTree.Add(i, i)
+		expected = append(expected, avltree.DiffEntry[int, int]{
+			Kind:     avltree.DiffAdded,
+			Key:      i,
+			NewValue: i,
+		})
+	}
+
+	entries := []avltree.DiffEntry[int, int]{}
+	err := avltree.Diff(oldTree, newTree, func(x, y int) bool {
+		return x == y
+	}, func(entry avltree.DiffEntry[int, int]) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, expected, entries)
+}
+
+func TestDiffSkipsSharedSubtrees(t *testing.T) {
+	newTree := &avltree.AVLTree[int, int]{}
+
+	for i := 0; i < 127; i++ {
+		newTree.Add(i, i)
+	}
+
+	// the snapshot shares all the nodes
+	// but the path to the added key
+	oldTree, err := newTree.Snapshot()
+	assert.Nil(t, err)
+	defer oldTree.Release()
+
+	newTree.Add(200, 200)
+
+	compared := 0
+	entries := []avltree.DiffEntry[int, int]{}
+	err = avltree.Diff(oldTree, newTree, func(x, y int) bool {
+		compared++
+		return x == y
+	}, func(entry avltree.DiffEntry[int, int]) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	assert.Nil(t, err)
+	// only the copied path
+	// to the new key is compared
+	assert.Equal(t, 7, compared)
+	assert.Equal(t, []avltree.DiffEntry[int, int]{{
+		Kind:     avltree.DiffAdded,
+		Key:      200,
+		NewValue: 200,
+	}}, entries)
+}
+
+func TestDiffUnrestricted(t *testing.T) {
+	oldTree := &avltree.UnrestrictedAVLTree[IntKey, int]{}
+	newTree := &avltree.UnrestrictedAVLTree[IntKey, int]{}
+
+	for i := 0; i < 10; i++ {
+		oldTree.Add(IntKey(i), i)
+		newTree.Add(IntKey(i+5), i+5)
+	}
+
+	kinds := map[avltree.DiffKind]int{}
+	err := avltree.DiffUnrestricted(oldTree, newTree, func(x, y int) bool {
+		return x == y
+	}, func(entry avltree.DiffEntry[IntKey, int]) error {
+		kinds[entry.Kind]++
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[avltree.DiffKind]int{
+		avltree.DiffAdded:   5,
+		avltree.DiffRemoved: 5,
+	}, kinds)
+}
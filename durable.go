@@ -0,0 +1,816 @@
+package avltree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/exp/constraints"
+)
+
+const (
+	// durableLogFile is the name of the
+	// write-ahead log in the tree directory.
+	durableLogFile = "wal.log"
+	// durableSnapshotFile is the name of
+	// the compacted log in the tree directory.
+	durableSnapshotFile = "snapshot.db"
+	// defaultCompactionSize is the log size
+	// which triggers the compaction by default.
+	defaultCompactionSize = 64 << 20
+	// recordHeaderSize is the size of the payload
+	// length, its checksum and the payload checksum.
+	recordHeaderSize = 12
+)
+
+// Kinds of the logged operations.
+const (
+	walPut byte = iota + 1
+	walDelete
+)
+
+// walTable is the CRC-32C table
+// for the record checksums.
+var walTable = crc32.MakeTable(crc32.Castagnoli)
+
+// SyncPolicy tells when the log is
+// flushed to the storage device.
+type SyncPolicy int
+
+const (
+	// SyncAlways flushes the log after
+	// each write before it returns.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval flushes the log on a write
+	// if the interval has passed since the
+	// last flush. The writes made since then
+	// can be lost if the system crashes.
+	SyncInterval
+	// SyncNever leaves flushing to the
+	// operating system, to Sync and to Close.
+	SyncNever
+)
+
+// DurableAVLTree is an AVL tree which survives process
+// crashes. Each write is appended to a checksummed log
+// file before it's applied to the tree in memory. When
+// the tree is opened, the log is replayed. Once the log
+// grows large enough, it's compacted into a snapshot file
+// holding the current entries. The tree isn't safe for
+// concurrent use and the directory must only be used by
+// one tree at a time.
+type DurableAVLTree[TKey constraints.Ordered, TValue any] struct {
+	tree AVLTree[TKey, TValue]
+	dir  string
+	log  *os.File
+	// logSize is the length of the
+	// valid part of the log.
+	logSize int64
+	// record is the scratch buffer
+	// for the encoded records.
+	record []byte
+
+	syncPolicy     SyncPolicy
+	syncInterval   time.Duration
+	lastSync       time.Time
+	compactionSize int64
+
+	encodeKey   func(key TKey) ([]byte, error)
+	decodeKey   func(data []byte) (TKey, error)
+	encodeValue func(value TValue) ([]byte, error)
+	decodeValue func(data []byte) (TValue, error)
+
+	// compactionErr is the failure of
+	// the last automatic compaction.
+	compactionErr error
+	closed        bool
+}
+
+// walOp is a single change in a log record.
+// All the changes are blind writes, so
+// replaying them twice is harmless.
+type walOp[TKey constraints.Ordered, TValue any] struct {
+	kind  byte
+	key   TKey
+	value TValue
+}
+
+// Add sets the value by the key.
+func (t *DurableAVLTree[TKey, TValue]) Add(key TKey, value TValue) error {
+	err := t.write(walOp[TKey, TValue]{
+		kind:  walPut,
+		key:   key,
+		value: value,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	t.tree.Add(key, value)
+	t.compactIfNeeded()
+
+	return nil
+}
+
+// AddOrUpdate adds the value by the key if there's no such key
+// in the tree. Otherwise it sets the value returned by upd for
+// the current value. If upd fails, the tree stays unchanged.
+func (t *DurableAVLTree[TKey, TValue]) AddOrUpdate(
+	key TKey, value TValue,
+	upd func(oldValue TValue) (TValue, error),
+) error {
+	if node := t.tree.Search(key); node != nil {
+		var err error
+		value, err = upd(node.Value)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return t.Add(key, value)
+}
+
+// Remove removes the key from the tree.
+func (t *DurableAVLTree[TKey, TValue]) Remove(key TKey) error {
+	if t.closed {
+		return &ErrorDurableTreeClosed{}
+	}
+
+	if t.tree.Search(key) == nil {
+		return nil
+	}
+
+	err := t.write(walOp[TKey, TValue]{
+		kind: walDelete,
+		key:  key,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	t.tree.Remove(key)
+	t.compactIfNeeded()
+
+	return nil
+}
+
+// Update moves the entry of the old key to the new key
+// and sets the new value. Returns false and leaves the
+// tree unchanged if there's no old key in the tree.
+func (t *DurableAVLTree[TKey, TValue]) Update(oldKey TKey, newKey TKey, newValue TValue) (bool, error) {
+	if t.closed {
+		return false, &ErrorDurableTreeClosed{}
+	}
+
+	if t.tree.Search(oldKey) == nil {
+		return false, nil
+	}
+
+	put := walOp[TKey, TValue]{
+		kind:  walPut,
+		key:   newKey,
+		value: newValue,
+	}
+	var err error
+
+	// both changes are in the same record
+	// so neither is applied without the other
+	if newKey == oldKey {
+		err = t.write(put)
+	} else {
+		err = t.write(walOp[TKey, TValue]{
+			kind: walDelete,
+			key:  oldKey,
+		}, put)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	t.tree.Update(oldKey, newKey, newValue)
+	t.compactIfNeeded()
+
+	return true, nil
+}
+
+// Search returns the value stored by the key
+// and whether there's such key in the tree.
+func (t *DurableAVLTree[TKey, TValue]) Search(key TKey) (TValue, bool) {
+	var zeroValTValue TValue
+	node := t.tree.Search(key)
+
+	if node == nil {
+		return zeroValTValue, false
+	}
+
+	return node.Value, true
+}
+
+// VisitInOrder visits the entries in the
+// ascending order of keys. The visit
+// function must not write to the tree.
+func (t *DurableAVLTree[TKey, TValue]) VisitInOrder(visit func(key TKey, value TValue) error) error {
+	return t.tree.VisitInOrder(func(node *AVLNode[TKey, TValue]) error {
+		return visit(node.key, node.Value)
+	})
+}
+
+// Len returns the number
+// of entries in the tree.
+func (t *DurableAVLTree[TKey, TValue]) Len() int {
+	return t.tree.Len()
+}
+
+// Sync flushes the log
+// to the storage device.
+func (t *DurableAVLTree[TKey, TValue]) Sync() error {
+	if t.closed {
+		return &ErrorDurableTreeClosed{}
+	}
+
+	err := t.log.Sync()
+
+	if err != nil {
+		return err
+	}
+
+	t.lastSync = time.Now()
+
+	return nil
+}
+
+// Compact writes all the entries to a new snapshot
+// file which atomically replaces the old one and then
+// empties the log. If the process crashes before the
+// log is emptied, the log is replayed over the new
+// snapshot which gives the same entries.
+func (t *DurableAVLTree[TKey, TValue]) Compact() error {
+	if t.closed {
+		return &ErrorDurableTreeClosed{}
+	}
+
+	path := filepath.Join(t.dir, durableSnapshotFile)
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+
+	if err != nil {
+		return err
+	}
+
+	err = t.writeSnapshot(file)
+	closeErr := file.Close()
+
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	err = os.Rename(tmpPath, path)
+
+	if err != nil {
+		return err
+	}
+
+	err = syncDir(t.dir)
+
+	if err != nil {
+		return err
+	}
+
+	err = t.log.Truncate(0)
+
+	if err != nil {
+		return err
+	}
+
+	t.logSize = 0
+
+	return t.Sync()
+}
+
+// CompactionError returns the failure of the last
+// automatic compaction. It's nil if the compaction
+// has succeeded or hasn't been needed. The writes
+// don't fail if the compaction does since they're
+// already in the log.
+func (t *DurableAVLTree[TKey, TValue]) CompactionError() error {
+	return t.compactionErr
+}
+
+// Close flushes the log and closes it.
+// The tree can't be used after that.
+func (t *DurableAVLTree[TKey, TValue]) Close() error {
+	if t.closed {
+		return &ErrorDurableTreeClosed{}
+	}
+
+	err := t.log.Sync()
+	closeErr := t.log.Close()
+	t.closed = true
+
+	if err != nil {
+		return err
+	}
+
+	return closeErr
+}
+
+// Writes all the entries to the snapshot file
+func (t *DurableAVLTree[TKey, TValue]) writeSnapshot(file *os.File) error {
+	writer := bufio.NewWriter(file)
+	err := t.tree.VisitInOrder(func(node *AVLNode[TKey, TValue]) error {
+		record, err := t.encode(walOp[TKey, TValue]{
+			kind:  walPut,
+			key:   node.key,
+			value: node.Value,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		_, err = writer.Write(record)
+
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	err = writer.Flush()
+
+	if err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// Appends a record with the operations to the log and
+// flushes it according to the sync policy. If the write
+// fails, the log is cut back to the previous record
+func (t *DurableAVLTree[TKey, TValue]) write(ops ...walOp[TKey, TValue]) error {
+	if t.closed {
+		return &ErrorDurableTreeClosed{}
+	}
+
+	record, err := t.encode(ops...)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = t.log.Write(record)
+
+	if err == nil {
+		switch {
+		case t.syncPolicy == SyncAlways,
+			t.syncPolicy == SyncInterval && time.Since(t.lastSync) >= t.syncInterval:
+			err = t.Sync()
+		}
+	}
+
+	if err != nil {
+		t.log.Truncate(t.logSize)
+		return err
+	}
+
+	t.logSize += int64(len(record))
+
+	return nil
+}
+
+// Compacts the log if it has outgrown the compaction
+// size. The write is already durable at this point,
+// so the failure is kept rather than returned and
+// the compaction is retried by the next write
+func (t *DurableAVLTree[TKey, TValue]) compactIfNeeded() {
+	if t.compactionSize <= 0 || t.logSize < t.compactionSize {
+		return
+	}
+
+	t.compactionErr = t.Compact()
+}
+
+// Encodes the operations into a record: the length
+// of the payload, the CRC-32C of the length and the
+// CRC-32C of the payload followed by the payload.
+// The record stays valid until the next call
+func (t *DurableAVLTree[TKey, TValue]) encode(ops ...walOp[TKey, TValue]) ([]byte, error) {
+	record := append(t.record[:0], make([]byte, recordHeaderSize)...)
+
+	for _, op := range ops {
+		key, err := t.encodeKey(op.key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		record = append(record, op.kind)
+		record = binary.AppendUvarint(record, uint64(len(key)))
+		record = append(record, key...)
+
+		if op.kind != walPut {
+			continue
+		}
+
+		value, err := t.encodeValue(op.value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		record = binary.AppendUvarint(record, uint64(len(value)))
+		record = append(record, value...)
+	}
+
+	payload := record[recordHeaderSize:]
+	binary.LittleEndian.PutUint32(record, uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:], crc32.Checksum(record[:4], walTable))
+	binary.LittleEndian.PutUint32(record[8:], crc32.Checksum(payload, walTable))
+	t.record = record
+
+	return record, nil
+}
+
+// Applies the records read from the file to the tree.
+// Returns the length of the valid prefix of the file.
+// It's shorter than the file only if the last record
+// runs past the end of the file, doesn't match its
+// payload checksum or the file ends with zeros, i.e.
+// the record has been torn by a crash. Any other
+// record which doesn't match its checksums means
+// the file is corrupted
+func (t *DurableAVLTree[TKey, TValue]) replay(file *os.File) (int64, error) {
+	var header [recordHeaderSize]byte
+	info, err := file.Stat()
+
+	if err != nil {
+		return 0, err
+	}
+
+	size := info.Size()
+	reader := bufio.NewReader(file)
+	offset := int64(0)
+	payload := []byte{}
+
+	for {
+		if size-offset < recordHeaderSize {
+			return offset, nil
+		}
+
+		_, err := io.ReadFull(reader, header[:])
+
+		if err != nil {
+			return offset, err
+		}
+
+		length := binary.LittleEndian.Uint32(header[:])
+		lengthChecksum := binary.LittleEndian.Uint32(header[4:])
+		checksum := binary.LittleEndian.Uint32(header[8:])
+
+		// the length is checked on its own so a damaged
+		// one isn't taken for a record running past the
+		// end of the file
+		if crc32.Checksum(header[:4], walTable) != lengthChecksum {
+			zeros, err := zeroTail(file, offset, size)
+
+			if err != nil {
+				return offset, err
+			}
+
+			if zeros {
+				return offset, nil
+			}
+
+			return offset, &ErrorCorruptedFile{
+				path:   file.Name(),
+				offset: offset,
+				reason: "length checksum mismatch",
+			}
+		}
+
+		if int64(length) > size-offset-recordHeaderSize {
+			return offset, nil
+		}
+
+		if cap(payload) < int(length) {
+			payload = make([]byte, length)
+		}
+
+		payload = payload[:length]
+		_, err = io.ReadFull(reader, payload)
+
+		if err != nil {
+			return offset, err
+		}
+
+		if crc32.Checksum(payload, walTable) != checksum {
+			// the header of the last record may have
+			// reached the disk without its payload
+			if offset+recordHeaderSize+int64(length) == size {
+				return offset, nil
+			}
+
+			return offset, &ErrorCorruptedFile{
+				path:   file.Name(),
+				offset: offset,
+				reason: "payload checksum mismatch",
+			}
+		}
+
+		err = t.apply(payload)
+
+		if err != nil {
+			return offset, &ErrorCorruptedFile{
+				path:   file.Name(),
+				offset: offset,
+				reason: err.Error(),
+			}
+		}
+
+		offset += recordHeaderSize + int64(length)
+	}
+}
+
+// Decodes the operations of the
+// record and applies them to the tree
+func (t *DurableAVLTree[TKey, TValue]) apply(payload []byte) error {
+	for len(payload) > 0 {
+		kind := payload[0]
+		payload = payload[1:]
+
+		if kind != walPut && kind != walDelete {
+			return errors.New("unknown operation")
+		}
+
+		data, rest, err := readChunk(payload)
+
+		if err != nil {
+			return err
+		}
+
+		key, err := t.decodeKey(data)
+
+		if err != nil {
+			return err
+		}
+
+		payload = rest
+
+		if kind == walDelete {
+			t.tree.Remove(key)
+			continue
+		}
+
+		data, rest, err = readChunk(payload)
+
+		if err != nil {
+			return err
+		}
+
+		value, err := t.decodeValue(data)
+
+		if err != nil {
+			return err
+		}
+
+		payload = rest
+		t.tree.Add(key, value)
+	}
+
+	return nil
+}
+
+// Tells if the file has only
+// zeros from the offset on
+func zeroTail(file *os.File, offset, size int64) (bool, error) {
+	var buffer [4096]byte
+
+	for offset < size {
+		n, err := file.ReadAt(buffer[:], offset)
+
+		for _, b := range buffer[:n] {
+			if b != 0 {
+				return false, nil
+			}
+		}
+
+		offset += int64(n)
+
+		if err == io.EOF {
+			return true, nil
+		}
+
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// Splits the length-prefixed chunk
+// off the beginning of the data
+func readChunk(data []byte) ([]byte, []byte, error) {
+	length, n := binary.Uvarint(data)
+
+	if n <= 0 || length > uint64(len(data)-n) {
+		return nil, nil, errors.New("truncated operation")
+	}
+
+	end := n + int(length)
+
+	return data[n:end], data[end:], nil
+}
+
+// Flushes the directory entries
+// so the renames are durable
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+
+	if err != nil {
+		return err
+	}
+
+	err = file.Sync()
+	closeErr := file.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return closeErr
+}
+
+func gobEncode[T any](value T) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	err := gob.NewEncoder(buffer).Encode(value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func gobDecode[T any](data []byte) (T, error) {
+	var value T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+
+	return value, err
+}
+
+// OpenDurableAVLTree opens the durable AVL tree stored in
+// the directory creating it if needed. The snapshot is
+// loaded and the log is replayed over it. If the last
+// record of the log has been torn by a crash, it's cut
+// off. A damaged record in the middle of the log makes
+// it fail with ErrorCorruptedFile and the log is left
+// as is. The keys and the values are encoded with gob
+// unless the codecs are set by the options.
+func OpenDurableAVLTree[
+	TKey constraints.Ordered, TValue any,
+](
+	dir string,
+	options ...DurableAVLTreeOption[TKey, TValue],
+) (
+	*DurableAVLTree[TKey, TValue], error,
+) {
+	tree := &DurableAVLTree[TKey, TValue]{
+		dir:            dir,
+		syncPolicy:     SyncAlways,
+		compactionSize: defaultCompactionSize,
+		encodeKey:      gobEncode[TKey],
+		decodeKey:      gobDecode[TKey],
+		encodeValue:    gobEncode[TValue],
+		decodeValue:    gobDecode[TValue],
+	}
+
+	for i := 0; i < len(options); i++ {
+		option := options[i]
+		err := option(tree)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := os.MkdirAll(dir, 0o755)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = tree.loadSnapshot()
+
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, durableLogFile)
+	log, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	valid, err := tree.replay(log)
+
+	if err == nil {
+		err = tree.cutLog(log, valid)
+	}
+
+	// the log may have just been created
+	if err == nil {
+		err = syncDir(dir)
+	}
+
+	if err != nil {
+		log.Close()
+		return nil, err
+	}
+
+	tree.log = log
+	tree.logSize = valid
+	tree.lastSync = time.Now()
+
+	return tree, nil
+}
+
+// Loads the entries of the snapshot if there's one.
+// The snapshot is written atomically, so an invalid
+// record in it means the file is corrupted
+func (t *DurableAVLTree[TKey, TValue]) loadSnapshot() error {
+	path := filepath.Join(t.dir, durableSnapshotFile)
+	file, err := os.Open(path)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	valid, err := t.replay(file)
+
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	if valid < info.Size() {
+		return &ErrorCorruptedFile{
+			path:   path,
+			offset: valid,
+			reason: "invalid record",
+		}
+	}
+
+	return nil
+}
+
+// Cuts the invalid tail off the log
+func (t *DurableAVLTree[TKey, TValue]) cutLog(log *os.File, valid int64) error {
+	info, err := log.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	if valid >= info.Size() {
+		return nil
+	}
+
+	err = log.Truncate(valid)
+
+	if err != nil {
+		return err
+	}
+
+	return log.Sync()
+}
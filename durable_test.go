@@ -0,0 +1,317 @@
+package avltree_test
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zergon321/go-avltree"
+)
+
+func durableEntries(t *testing.T, tree *avltree.DurableAVLTree[int, string]) map[int]string {
+	entries := map[int]string{}
+	err := tree.VisitInOrder(func(key int, value string) error {
+		entries[key] = value
+		return nil
+	})
+	assert.Nil(t, err)
+
+	return entries
+}
+
+func TestDurableAVLTreeReopen(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := avltree.OpenDurableAVLTree[int, string](dir)
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, tree.Add(i, strconv.Itoa(i)))
+	}
+
+	assert.Nil(t, tree.Remove(3))
+	assert.Nil(t, tree.Remove(100))
+	ok, err := tree.Update(4, 40, "forty")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = tree.Update(4, 41, "none")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	err = tree.AddOrUpdate(5, "", func(oldValue string) (string, error) {
+		return oldValue + "5", nil
+	})
+	assert.Nil(t, err)
+
+	expected := durableEntries(t, tree)
+	assert.Nil(t, tree.Close())
+	assert.NotNil(t, tree.Add(1, "1"))
+
+	tree, err = avltree.OpenDurableAVLTree[int, string](dir)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, durableEntries(t, tree))
+	value, ok := tree.Search(5)
+	assert.True(t, ok)
+	assert.Equal(t, "55", value)
+	assert.Nil(t, tree.Close())
+}
+
+func TestDurableAVLTreeTornTail(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := avltree.OpenDurableAVLTree[int, string](dir)
+	assert.Nil(t, err)
+	assert.Nil(t, tree.Add(1, "1"))
+	assert.Nil(t, tree.Add(2, "2"))
+	assert.Nil(t, tree.Close())
+
+	// cut the last record short as if
+	// the process crashed while writing it
+	path := filepath.Join(dir, "wal.log")
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Truncate(path, info.Size()-3))
+
+	tree, err = avltree.OpenDurableAVLTree[int, string](dir)
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]string{1: "1"}, durableEntries(t, tree))
+
+	// the writes after the torn
+	// record must be replayed
+	assert.Nil(t, tree.Add(3, "3"))
+	assert.Nil(t, tree.Close())
+
+	tree, err = avltree.OpenDurableAVLTree[int, string](dir)
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]string{1: "1", 3: "3"}, durableEntries(t, tree))
+	assert.Nil(t, tree.Close())
+
+	// the file system may leave zeros in
+	// place of the record torn by a crash
+	info, err = os.Stat(path)
+	assert.Nil(t, err)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	assert.Nil(t, err)
+	_, err = file.Write(make([]byte, 100))
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	tree, err = avltree.OpenDurableAVLTree[int, string](dir)
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]string{1: "1", 3: "3"}, durableEntries(t, tree))
+	assert.Nil(t, tree.Close())
+
+	stored, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, info.Size(), stored.Size())
+
+	// the header of the last record may
+	// reach the disk without its payload
+	tree, err = avltree.OpenDurableAVLTree[int, string](dir)
+	assert.Nil(t, err)
+	assert.Nil(t, tree.Add(4, "4"))
+	assert.Nil(t, tree.Close())
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+
+	for i := int(info.Size()) + 12; i < len(data); i++ {
+		data[i] = 0
+	}
+
+	assert.Nil(t, os.WriteFile(path, data, 0o644))
+
+	tree, err = avltree.OpenDurableAVLTree[int, string](dir)
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]string{1: "1", 3: "3"}, durableEntries(t, tree))
+	assert.Nil(t, tree.Close())
+
+	stored, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, info.Size(), stored.Size())
+}
+
+func TestDurableAVLTreeCorruptedLog(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := avltree.OpenDurableAVLTree[int, string](dir)
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, tree.Add(i, strconv.Itoa(i)))
+	}
+
+	assert.Nil(t, tree.Close())
+
+	path := filepath.Join(dir, "wal.log")
+	original, err := os.ReadFile(path)
+	assert.Nil(t, err)
+
+	// a damaged record in the middle of the log isn't
+	// a torn tail, so nothing after it is cut off
+	for _, offset := range []int{len(original) / 4, 0} {
+		data := append([]byte{}, original...)
+		data[offset] ^= 0xff
+		assert.Nil(t, os.WriteFile(path, data, 0o644))
+
+		_, err = avltree.OpenDurableAVLTree[int, string](dir)
+		assert.IsType(t, &avltree.ErrorCorruptedFile{}, err)
+
+		stored, err := os.ReadFile(path)
+		assert.Nil(t, err)
+		assert.Equal(t, data, stored)
+	}
+}
+
+func TestDurableAVLTreeCompaction(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := avltree.OpenDurableAVLTree(dir,
+		avltree.DurableAVLTreeOptionWithCompaction[int, string](1024),
+		avltree.DurableAVLTreeOptionWithSync[int, string](avltree.SyncNever, 0))
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, tree.Add(i%20, strconv.Itoa(i)))
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "wal.log"))
+	assert.Nil(t, err)
+	assert.Less(t, info.Size(), int64(1024))
+	_, err = os.Stat(filepath.Join(dir, "snapshot.db"))
+	assert.Nil(t, err)
+
+	ok, err := tree.Update(19, 30, "moved")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, tree.Add(19, "back"))
+	assert.Nil(t, tree.Remove(0))
+
+	// keep the log as if the process crashed
+	// right after the snapshot was written
+	log, err := os.ReadFile(filepath.Join(dir, "wal.log"))
+	assert.Nil(t, err)
+	assert.Nil(t, tree.Compact())
+	expected := durableEntries(t, tree)
+	assert.Nil(t, tree.Close())
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "wal.log"), log, 0o644))
+
+	tree, err = avltree.OpenDurableAVLTree[int, string](dir)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, durableEntries(t, tree))
+	assert.Nil(t, tree.Close())
+}
+
+func TestDurableAVLTreeRandom(t *testing.T) {
+	dir := t.TempDir()
+	options := []avltree.DurableAVLTreeOption[int, string]{
+		avltree.DurableAVLTreeOptionWithCompaction[int, string](4096),
+		avltree.DurableAVLTreeOptionWithSync[int, string](avltree.SyncInterval, time.Millisecond),
+	}
+	tree, err := avltree.OpenDurableAVLTree(dir, options...)
+	assert.Nil(t, err)
+	model := map[int]string{}
+	rnd := rand.New(rand.NewSource(50))
+
+	for i := 0; i < 2000; i++ {
+		key := rnd.Intn(100)
+
+		switch rnd.Intn(4) {
+		case 0:
+			assert.Nil(t, tree.Add(key, strconv.Itoa(i)))
+			model[key] = strconv.Itoa(i)
+
+		case 1:
+			assert.Nil(t, tree.Remove(key))
+			delete(model, key)
+
+		case 2:
+			newKey := rnd.Intn(100)
+			_, exists := model[key]
+			ok, err := tree.Update(key, newKey, strconv.Itoa(i))
+			assert.Nil(t, err)
+			assert.Equal(t, exists, ok)
+
+			if exists {
+				delete(model, key)
+				model[newKey] = strconv.Itoa(i)
+			}
+
+		case 3:
+			assert.Nil(t, tree.Close())
+			tree, err = avltree.OpenDurableAVLTree(dir, options...)
+			assert.Nil(t, err)
+		}
+	}
+
+	assert.Equal(t, model, durableEntries(t, tree))
+	assert.Equal(t, len(model), tree.Len())
+	assert.Nil(t, tree.Close())
+}
+
+func TestDurableAVLTreeOptions(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := avltree.OpenDurableAVLTree(dir,
+		avltree.DurableAVLTreeOptionWithSync[int, string](avltree.SyncInterval, 0))
+	assert.IsType(t, &avltree.ErrorNonPositiveSyncInterval{}, err)
+
+	_, err = avltree.OpenDurableAVLTree(dir,
+		avltree.DurableAVLTreeOptionWithSync[int, string](avltree.SyncPolicy(7), 0))
+	assert.IsType(t, &avltree.ErrorUnknownSyncPolicy{}, err)
+
+	_, err = avltree.OpenDurableAVLTree(dir,
+		avltree.DurableAVLTreeOptionWithCompaction[int, string](-1))
+	assert.IsType(t, &avltree.ErrorNegativeCompactionSize{}, err)
+
+	tree, err := avltree.OpenDurableAVLTree(dir,
+		avltree.DurableAVLTreeOptionWithValueCodec[int](
+			func(value string) ([]byte, error) {
+				return []byte(value), nil
+			},
+			func(data []byte) (string, error) {
+				return string(data), nil
+			}))
+	assert.Nil(t, err)
+	assert.Nil(t, tree.Add(1, "one"))
+	assert.Nil(t, tree.Compact())
+	assert.Nil(t, tree.Close())
+
+	// the snapshot is written atomically,
+	// so a damaged one isn't repaired
+	path := filepath.Join(dir, "snapshot.db")
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	data[len(data)-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(path, data, 0o644))
+	_, err = avltree.OpenDurableAVLTree[int, string](dir)
+	assert.IsType(t, &avltree.ErrorCorruptedFile{}, err)
+}
+
+func TestDurableAVLTreeCompactionFailure(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := avltree.OpenDurableAVLTree(dir,
+		avltree.DurableAVLTreeOptionWithCompaction[int, string](64))
+	assert.Nil(t, err)
+
+	// the snapshot can't be written
+	// in place of the directory
+	tmpPath := filepath.Join(dir, "snapshot.db.tmp")
+	assert.Nil(t, os.Mkdir(tmpPath, 0o755))
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, tree.Add(i, strconv.Itoa(i)))
+	}
+
+	assert.NotNil(t, tree.CompactionError())
+	assert.Equal(t, 10, tree.Len())
+
+	assert.Nil(t, os.Remove(tmpPath))
+	assert.Nil(t, tree.Add(10, "10"))
+	assert.Nil(t, tree.CompactionError())
+	assert.Nil(t, tree.Close())
+
+	tree, err = avltree.OpenDurableAVLTree[int, string](dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 11, tree.Len())
+	assert.Nil(t, tree.Close())
+}
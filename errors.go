@@ -0,0 +1,281 @@
+package avltree
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrorInvalidTree is returned by Validate
+// if one of the structural invariants
+// of the tree is broken.
+type ErrorInvalidTree struct {
+	path   string
+	reason string
+}
+
+// Path returns the path from the root
+// to the offending node, e.g. "root.left.right".
+func (err *ErrorInvalidTree) Path() string {
+	return err.path
+}
+
+// Error returns the error message.
+func (err *ErrorInvalidTree) Error() string {
+	return fmt.Sprintf("invalid tree node at %s: %s", err.path, err.reason)
+}
+
+/*===============================================================*/
+
+// ErrorNonPositiveChunkSize is returned
+// if the chunk size of the slab allocator
+// is zero or negative.
+type ErrorNonPositiveChunkSize struct {
+	chunkSize int
+}
+
+// Error returns the error message.
+func (err *ErrorNonPositiveChunkSize) Error() string {
+	return fmt.Sprintf("got non-positive chunk size: %d", err.chunkSize)
+}
+
+/*===============================================================*/
+
+// ErrorNegativeCapacity is returned if
+// a negative value has been passed for
+// the tree capacity as an option.
+type ErrorNegativeCapacity struct {
+	capacity int
+}
+
+// Error returns the error message.
+func (err *ErrorNegativeCapacity) Error() string {
+	return fmt.Sprintf("got negative capacity: %d", err.capacity)
+}
+
+/*===============================================================*/
+
+// ErrorKeyExists is returned if
+// the key to be set already exists
+// in the tree.
+type ErrorKeyExists struct {
+	key any
+}
+
+// Error returns the error message.
+func (err *ErrorKeyExists) Error() string {
+	return fmt.Sprintf("key already exists: %v", err.key)
+}
+
+/*===============================================================*/
+
+// ErrorUnknownAction is returned
+// if the Upsert callback returns
+// an action which is not defined.
+type ErrorUnknownAction struct {
+	action Action
+}
+
+// Error returns the error message.
+func (err *ErrorUnknownAction) Error() string {
+	return fmt.Sprintf("unknown upsert action: %d", err.action)
+}
+
+/*===============================================================*/
+
+// ErrorUnknownBackpressure is returned
+// if the watcher backpressure policy
+// is not defined.
+type ErrorUnknownBackpressure struct {
+	policy Backpressure
+}
+
+// Error returns the error message.
+func (err *ErrorUnknownBackpressure) Error() string {
+	return fmt.Sprintf("unknown backpressure policy: %d", err.policy)
+}
+
+/*===============================================================*/
+
+// ErrorNonPositiveMaxSize is returned
+// if the maximum size of the tree
+// is zero or negative.
+type ErrorNonPositiveMaxSize struct {
+	maxSize int
+}
+
+// Error returns the error message.
+func (err *ErrorNonPositiveMaxSize) Error() string {
+	return fmt.Sprintf("got non-positive max size: %d", err.maxSize)
+}
+
+/*===============================================================*/
+
+// ErrorUnknownEvictionPolicy is returned
+// if the eviction policy of the tree
+// is not defined.
+type ErrorUnknownEvictionPolicy struct {
+	policy EvictionPolicy
+}
+
+// Error returns the error message.
+func (err *ErrorUnknownEvictionPolicy) Error() string {
+	return fmt.Sprintf("unknown eviction policy: %d", err.policy)
+}
+
+/*===============================================================*/
+
+// ErrorTxnClosed is returned if
+// the transaction has already been
+// committed or rolled back.
+type ErrorTxnClosed struct{}
+
+// Error returns the error message.
+func (err *ErrorTxnClosed) Error() string {
+	return "transaction is already closed"
+}
+
+/*===============================================================*/
+
+// ErrorTxnEvictsOwnWrite is returned by Commit
+// if the size bound of the tree would evict
+// an entry written by the transaction.
+type ErrorTxnEvictsOwnWrite struct{}
+
+// Error returns the error message.
+func (err *ErrorTxnEvictsOwnWrite) Error() string {
+	return "the commit would evict the entries written by the transaction"
+}
+
+/*===============================================================*/
+
+// ErrorHashingDisabled is returned
+// if the tree hashes are requested
+// but hashing isn't enabled.
+type ErrorHashingDisabled struct{}
+
+// Error returns the error message.
+func (err *ErrorHashingDisabled) Error() string {
+	return "hashing is not enabled for the tree"
+}
+
+/*===============================================================*/
+
+// ErrorSnapshotWithParentLinks is returned
+// if a snapshot is requested for a tree
+// with parent links enabled.
+type ErrorSnapshotWithParentLinks struct{}
+
+// Error returns the error message.
+func (err *ErrorSnapshotWithParentLinks) Error() string {
+	return "snapshots can't be taken of a tree with parent links"
+}
+
+/*===============================================================*/
+
+// ErrorInvalidSplitPoints is returned
+// if the split points of the sharded
+// tree are not strictly ascending.
+type ErrorInvalidSplitPoints struct {
+	index int
+}
+
+// Error returns the error message.
+func (err *ErrorInvalidSplitPoints) Error() string {
+	return fmt.Sprintf("split point #%d is not greater than the previous one", err.index)
+}
+
+/*===============================================================*/
+
+// ErrorInvalidRebalanceRatio is returned
+// if the rebalance ratio of the sharded
+// tree is neither zero nor greater than 1.
+type ErrorInvalidRebalanceRatio struct {
+	ratio float64
+}
+
+// Error returns the error message.
+func (err *ErrorInvalidRebalanceRatio) Error() string {
+	return fmt.Sprintf("rebalance ratio must be zero or greater than 1, got %v", err.ratio)
+}
+
+/*===============================================================*/
+
+// ErrorNilClock is returned if the
+// clock of the expiring tree is nil.
+type ErrorNilClock struct{}
+
+// Error returns the error message.
+func (err *ErrorNilClock) Error() string {
+	return "the clock must not be nil"
+}
+
+/*===============================================================*/
+
+// ErrorDurableTreeClosed is returned
+// if the durable tree is used
+// after it's been closed.
+type ErrorDurableTreeClosed struct{}
+
+// Error returns the error message.
+func (err *ErrorDurableTreeClosed) Error() string {
+	return "the durable tree is closed"
+}
+
+/*===============================================================*/
+
+// ErrorCorruptedFile is returned if
+// a file of the durable tree holds
+// a record which can't be applied.
+type ErrorCorruptedFile struct {
+	path   string
+	offset int64
+	reason string
+}
+
+// Error returns the error message.
+func (err *ErrorCorruptedFile) Error() string {
+	return fmt.Sprintf("file %s is corrupted at offset %d: %s",
+		err.path, err.offset, err.reason)
+}
+
+/*===============================================================*/
+
+// ErrorUnknownSyncPolicy is returned
+// if the sync policy of the durable
+// tree is not one of the defined ones.
+type ErrorUnknownSyncPolicy struct {
+	policy SyncPolicy
+}
+
+// Error returns the error message.
+func (err *ErrorUnknownSyncPolicy) Error() string {
+	return fmt.Sprintf("unknown sync policy %d", err.policy)
+}
+
+/*===============================================================*/
+
+// ErrorNonPositiveSyncInterval is returned
+// if the interval policy of the durable
+// tree is set with no positive interval.
+type ErrorNonPositiveSyncInterval struct {
+	interval time.Duration
+}
+
+// Error returns the error message.
+func (err *ErrorNonPositiveSyncInterval) Error() string {
+	return fmt.Sprintf("sync interval must be positive, got %v", err.interval)
+}
+
+/*===============================================================*/
+
+// ErrorNegativeCompactionSize is returned
+// if the log size triggering the compaction
+// of the durable tree is negative.
+type ErrorNegativeCompactionSize struct {
+	size int64
+}
+
+// Error returns the error message.
+func (err *ErrorNegativeCompactionSize) Error() string {
+	return fmt.Sprintf("compaction size must not be negative, got %d", err.size)
+}
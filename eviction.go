@@ -0,0 +1,137 @@
+package avltree
+
+import (
+	"container/list"
+
+	"golang.org/x/exp/constraints"
+)
+
+// EvictionPolicy tells which entry is evicted
+// when the size-bounded tree exceeds its capacity.
+type EvictionPolicy int
+
+const (
+	// EvictSmallest evicts the
+	// entry with the smallest key.
+	EvictSmallest EvictionPolicy = iota
+	// EvictLargest evicts the
+	// entry with the largest key.
+	EvictLargest
+	// EvictLRU evicts the least recently
+	// used entry. An entry is used when
+	// it's added, updated or searched, so
+	// Search isn't a read-only operation
+	// and can't run concurrently.
+	EvictLRU
+)
+
+// avlBound limits the size
+// of the tree. It's nil if the
+// tree size is unlimited.
+type avlBound[TKey constraints.Ordered, TValue any] struct {
+	maxSize int
+	policy  EvictionPolicy
+	// choose is set if the victims are
+	// chosen by the user rather than
+	// by the policy.
+	choose  func(tree *AVLTree[TKey, TValue], key TKey) TKey
+	onEvict func(key TKey, value TValue)
+	// lru orders the keys from the most
+	// to the least recently used one. It's
+	// only maintained for EvictLRU.
+	lru      list.List
+	elements map[TKey]*list.Element
+}
+
+// Moves the key to the front of the
+// recently used ones adding it if needed
+func (b *avlBound[TKey, TValue]) touch(key TKey) {
+	if element, ok := b.elements[key]; ok {
+		b.lru.MoveToFront(element)
+		return
+	}
+
+	b.elements[key] = b.lru.PushFront(key)
+}
+
+// Forgets the use of the key
+func (b *avlBound[TKey, TValue]) forget(key TKey) {
+	if element, ok := b.elements[key]; ok {
+		b.lru.Remove(element)
+		delete(b.elements, key)
+	}
+}
+
+// Returns the size bound of the tree
+// creating the unlimited one if needed
+func (t *AVLTree[TKey, TValue]) sizeBound() *avlBound[TKey, TValue] {
+	if t.bound == nil {
+		t.bound = &avlBound[TKey, TValue]{}
+	}
+
+	return t.bound
+}
+
+// Makes the tree track the recently
+// used keys for the LRU eviction
+func (t *AVLTree[TKey, TValue]) trackUsage() {
+	bound := t.sizeBound()
+
+	if bound.elements != nil {
+		return
+	}
+
+	bound.elements = map[TKey]*list.Element{}
+	hooks := t.hookSet()
+	hooks.onInsert = append(hooks.onInsert, func(key TKey, value TValue) {
+		bound.touch(key)
+	})
+	hooks.onUpdate = append(hooks.onUpdate, func(key TKey, oldValue, newValue TValue) {
+		bound.touch(key)
+	})
+	hooks.onDelete = append(hooks.onDelete, func(key TKey, value TValue) {
+		bound.forget(key)
+	})
+}
+
+// Evicts an entry if the tree has outgrown its capacity
+// after the key has been added. The victim is chosen
+// by the policy or by the user callback. If the chosen
+// key isn't in the tree, the added entry is evicted
+func (t *AVLTree[TKey, TValue]) evict(key TKey) {
+	bound := t.bound
+
+	if bound.maxSize <= 0 || t.size <= bound.maxSize {
+		return
+	}
+
+	victim := key
+
+	switch {
+	case bound.choose != nil:
+		victim = bound.choose(t, key)
+
+	case bound.policy == EvictSmallest:
+		victim = t.root.findSmallest().key
+
+	case bound.policy == EvictLargest:
+		victim = t.root.findLargest().key
+
+	case bound.policy == EvictLRU:
+		victim = bound.lru.Back().Value.(TKey)
+	}
+
+	path, slot := t.find(victim)
+
+	if *slot == nil {
+		path, slot = t.find(key)
+	}
+
+	node := t.unlink(path, slot)
+
+	if bound.onEvict != nil {
+		bound.onEvict(node.key, node.Value)
+	}
+
+	t.release(node)
+}
@@ -0,0 +1,210 @@
+package avltree_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zergon321/go-avltree"
+)
+
+func treeKeys(tree *avltree.AVLTree[int, int]) []int {
+	keys := []int{}
+	tree.VisitInOrder(func(node *avltree.AVLNode[int, int]) error {
+		keys = append(keys, node.Key())
+		return nil
+	})
+
+	return keys
+}
+
+func TestEvictionPolicies(t *testing.T) {
+	for policy, expected := range map[avltree.EvictionPolicy][]int{
+		avltree.EvictSmallest: {3, 4, 5},
+		// the inserted keys are the largest
+		avltree.EvictLargest: {1, 2, 3},
+		// 1 and 3 are used after 2 and 4
+		avltree.EvictLRU: {1, 3, 5},
+	} {
+		evicted := []int{}
+		tree, err := avltree.NewAVLTree(
+			avltree.AVLTreeOptionWithMaxSize[int, int](3, policy),
+			avltree.AVLTreeOptionOnEvict(func(key, value int) {
+				evicted = append(evicted, key)
+			}),
+		)
+		assert.Nil(t, err)
+
+		tree.Add(1, 1)
+		tree.Add(2, 2)
+		tree.Add(3, 3)
+		tree.Search(1)
+		tree.Add(4, 4)
+		tree.Search(1)
+		tree.Add(3, 3)
+		tree.Add(5, 5)
+
+		assert.Equal(t, expected, treeKeys(tree), "policy %d", policy)
+		assert.Equal(t, 2, len(evicted))
+		assert.Nil(t, tree.Validate())
+	}
+}
+
+func TestEvictionFunc(t *testing.T) {
+	// evicts the key closest
+	// to the inserted one
+	tree, err := avltree.NewAVLTree(
+		avltree.AVLTreeOptionWithEvictionFunc(2,
+			func(tree *avltree.AVLTree[int, int], key int) int {
+				if key%2 == 0 {
+					return key - 1
+				}
+
+				return -1
+			}),
+	)
+	assert.Nil(t, err)
+
+	tree.Add(1, 1)
+	tree.Add(3, 3)
+	tree.Add(4, 4)
+	assert.Equal(t, []int{1, 4}, treeKeys(tree))
+
+	// the chosen key is absent
+	// so the new entry is evicted
+	value, existed := tree.GetOrInsert(5, func() int {
+		return 5
+	})
+	assert.False(t, existed)
+	assert.Equal(t, 5, *value)
+	assert.Equal(t, []int{1, 4}, treeKeys(tree))
+
+	_, err = avltree.NewAVLTree(avltree.AVLTreeOptionWithMaxSize[int, int](0, avltree.EvictLRU))
+	assert.NotNil(t, err)
+
+	_, err = avltree.NewAVLTree(avltree.AVLTreeOptionWithMaxSize[int, int](1, 42))
+
+	var policyErr *avltree.ErrorUnknownEvictionPolicy
+	assert.ErrorAs(t, err, &policyErr)
+}
+
+func TestEvictionRandom(t *testing.T) {
+	const maxSize = 50
+
+	tree, err := avltree.NewAVLTree(
+		avltree.AVLTreeOptionWithMaxSize[int, int](maxSize, avltree.EvictLRU))
+	assert.Nil(t, err)
+
+	// the most recently used
+	// keys are never evicted
+	recent := []int{}
+
+	for i := 0; i < 5000; i++ {
+		key := rand.Intn(200)
+
+		switch rand.Intn(4) {
+		case 0:
+			tree.Remove(key)
+
+			for j, used := range recent {
+				if used == key {
+					recent = append(recent[:j], recent[j+1:]...)
+					break
+				}
+			}
+
+			continue
+
+		case 1:
+			if tree.Search(key) == nil {
+				continue
+			}
+
+		default:
+			tree.Add(key, i)
+		}
+
+		for j, used := range recent {
+			if used == key {
+				recent = append(recent[:j], recent[j+1:]...)
+				break
+			}
+		}
+
+		recent = append(recent, key)
+
+		if len(recent) > maxSize {
+			recent = recent[1:]
+		}
+
+		assert.LessOrEqual(t, tree.Len(), maxSize)
+
+		if err := tree.Validate(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+
+	assert.Equal(t, len(recent), tree.Len())
+
+	for _, key := range recent {
+		assert.NotNil(t, tree.Search(key))
+	}
+}
+
+func TestEvictionGetOrInsert(t *testing.T) {
+	tree, err := avltree.NewAVLTree(
+		avltree.AVLTreeOptionWithMaxSize[int, int](2, avltree.EvictLRU))
+	assert.Nil(t, err)
+
+	tree.Add(1, 1)
+	tree.Add(2, 2)
+
+	_, ok := tree.GetOrInsert(1, func() int { return 0 })
+	assert.True(t, ok)
+
+	tree.Add(3, 3)
+	assert.Equal(t, []int{1, 3}, treeKeys(tree))
+}
+
+func TestEvictionClone(t *testing.T) {
+	tree, err := avltree.NewAVLTree[int, int]()
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		tree.Add(i, i)
+	}
+
+	evicted := []int{}
+	clone, err := tree.Clone(nil,
+		avltree.AVLTreeOptionWithMaxSize[int, int](5, avltree.EvictLRU),
+		avltree.AVLTreeOptionOnEvict(func(key, value int) {
+			evicted = append(evicted, key)
+		}))
+	assert.Nil(t, err)
+	assert.Equal(t, []int{5, 6, 7, 8, 9}, treeKeys(clone))
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, evicted)
+	assert.Nil(t, clone.Validate())
+
+	clone.Add(100, 100)
+	assert.Equal(t, []int{6, 7, 8, 9, 100}, treeKeys(clone))
+	assert.Equal(t, 10, tree.Len())
+
+	// the clone keeps the LRU
+	// order of the bounded tree
+	tree, err = avltree.NewAVLTree(
+		avltree.AVLTreeOptionWithMaxSize[int, int](10, avltree.EvictLRU))
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		tree.Add(i, i)
+	}
+
+	tree.Search(0)
+	clone, err = tree.Clone(nil,
+		avltree.AVLTreeOptionWithMaxSize[int, int](5, avltree.EvictLRU))
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0, 6, 7, 8, 9}, treeKeys(clone))
+
+	clone.Add(100, 100)
+	assert.Equal(t, []int{0, 7, 8, 9, 100}, treeKeys(clone))
+}
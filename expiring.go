@@ -0,0 +1,284 @@
+package avltree
+
+import (
+	"container/heap"
+	"time"
+
+	"golang.org/x/exp/constraints"
+)
+
+// ExpiringAVLTree is an AVL tree whose entries may have
+// a time to live. The expired entries are invisible to
+// the reads at once. They're removed when a read comes
+// across them or by Sweep, whatever happens first.
+// The tree must be created by NewExpiringAVLTree,
+// the zero value isn't ready for use.
+type ExpiringAVLTree[TKey constraints.Ordered, TValue any] struct {
+	tree     AVLTree[TKey, expiringEntry[TKey, TValue]]
+	expiries expiryHeap[TKey]
+	now      func() time.Time
+	onExpire func(key TKey, value TValue)
+}
+
+// expiringEntry is the value stored
+// in the tree. item is nil if the
+// entry never expires.
+type expiringEntry[TKey constraints.Ordered, TValue any] struct {
+	value TValue
+	item  *expiryItem[TKey]
+}
+
+// expiryItem is the deadline
+// of the key in the heap.
+type expiryItem[TKey constraints.Ordered] struct {
+	key      TKey
+	deadline time.Time
+	index    int
+}
+
+// expiryHeap orders the keys by
+// their deadlines, the earliest first.
+type expiryHeap[TKey constraints.Ordered] []*expiryItem[TKey]
+
+func (h expiryHeap[TKey]) Len() int {
+	return len(h)
+}
+
+func (h expiryHeap[TKey]) Less(i, j int) bool {
+	return h[i].deadline.Before(h[j].deadline)
+}
+
+func (h expiryHeap[TKey]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap[TKey]) Push(x any) {
+	item := x.(*expiryItem[TKey])
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *expiryHeap[TKey]) Pop() any {
+	old := *h
+	last := len(old) - 1
+	item := old[last]
+	old[last] = nil
+	*h = old[:last]
+
+	return item
+}
+
+// Add sets the value by the key. The entry expires
+// after the ttl passes. If the ttl is zero or negative,
+// the entry never expires.
+func (t *ExpiringAVLTree[TKey, TValue]) Add(key TKey, value TValue, ttl time.Duration) {
+	var (
+		expired      bool
+		expiredValue TValue
+	)
+
+	now := t.now()
+
+	t.tree.Upsert(key, func(
+		oldEntry expiringEntry[TKey, TValue], exists bool,
+	) (expiringEntry[TKey, TValue], Action, error) {
+		item := oldEntry.item
+
+		if exists && item != nil && !now.Before(item.deadline) {
+			expired = true
+			expiredValue = oldEntry.value
+		}
+
+		if ttl > 0 {
+			if item == nil {
+				item = &expiryItem[TKey]{
+					key:      key,
+					deadline: now.Add(ttl),
+				}
+				heap.Push(&t.expiries, item)
+			} else {
+				item.deadline = now.Add(ttl)
+				heap.Fix(&t.expiries, item.index)
+			}
+		} else if item != nil {
+			heap.Remove(&t.expiries, item.index)
+			item = nil
+		}
+
+		return expiringEntry[TKey, TValue]{
+			value: value,
+			item:  item,
+		}, ActionPut, nil
+	})
+
+	// the replaced entry has
+	// expired before the write
+	if expired && t.onExpire != nil {
+		t.onExpire(key, expiredValue)
+	}
+}
+
+// Remove removes the key from the tree.
+// Reports whether there has been an
+// unexpired entry with the key.
+func (t *ExpiringAVLTree[TKey, TValue]) Remove(key TKey) bool {
+	node := t.tree.Search(key)
+
+	if node == nil {
+		return false
+	}
+
+	entry := node.Value
+
+	if t.expired(entry) {
+		t.expire(key, entry)
+		return false
+	}
+
+	if entry.item != nil {
+		heap.Remove(&t.expiries, entry.item.index)
+	}
+
+	t.tree.Remove(key)
+
+	return true
+}
+
+// Search returns the value stored by the key and
+// whether there's such unexpired key in the tree.
+// If the entry has expired, it's removed.
+func (t *ExpiringAVLTree[TKey, TValue]) Search(key TKey) (TValue, bool) {
+	var zeroValTValue TValue
+	node := t.tree.Search(key)
+
+	if node == nil {
+		return zeroValTValue, false
+	}
+
+	entry := node.Value
+
+	if t.expired(entry) {
+		t.expire(key, entry)
+		return zeroValTValue, false
+	}
+
+	return entry.value, true
+}
+
+// Deadline returns the moment the entry
+// of the key expires. It's zero if
+// the entry never expires.
+func (t *ExpiringAVLTree[TKey, TValue]) Deadline(key TKey) (time.Time, bool) {
+	node := t.tree.Search(key)
+
+	if node == nil || t.expired(node.Value) {
+		return time.Time{}, false
+	}
+
+	if node.Value.item == nil {
+		return time.Time{}, true
+	}
+
+	return node.Value.item.deadline, true
+}
+
+// VisitInOrder visits the unexpired entries in the
+// ascending order of keys. The visit function must
+// not write to the tree.
+func (t *ExpiringAVLTree[TKey, TValue]) VisitInOrder(visit func(key TKey, value TValue) error) error {
+	now := t.now()
+
+	return t.tree.VisitInOrder(func(node *AVLNode[TKey, expiringEntry[TKey, TValue]]) error {
+		if node.Value.item != nil && !now.Before(node.Value.item.deadline) {
+			return nil
+		}
+
+		return visit(node.key, node.Value.value)
+	})
+}
+
+// Len returns the number of entries in the
+// tree including the expired ones which
+// haven't been removed yet.
+func (t *ExpiringAVLTree[TKey, TValue]) Len() int {
+	return t.tree.Len()
+}
+
+// Sweep removes all the entries expired by now
+// and reports their number. The expiry callback
+// is called for each of them in the order
+// of their deadlines.
+func (t *ExpiringAVLTree[TKey, TValue]) Sweep(now time.Time) int {
+	count := 0
+
+	for len(t.expiries) > 0 && !now.Before(t.expiries[0].deadline) {
+		item := heap.Pop(&t.expiries).(*expiryItem[TKey])
+		node := t.tree.Search(item.key)
+		value := node.Value.value
+		t.tree.Remove(item.key)
+		count++
+
+		if t.onExpire != nil {
+			t.onExpire(item.key, value)
+		}
+	}
+
+	return count
+}
+
+// Clear removes all the entries
+// without calling the expiry callback.
+func (t *ExpiringAVLTree[TKey, TValue]) Clear() {
+	t.tree.Clear()
+
+	for i := range t.expiries {
+		t.expiries[i] = nil
+	}
+
+	t.expiries = t.expiries[:0]
+}
+
+// Tells if the entry has
+// expired by the clock
+func (t *ExpiringAVLTree[TKey, TValue]) expired(entry expiringEntry[TKey, TValue]) bool {
+	return entry.item != nil && !t.now().Before(entry.item.deadline)
+}
+
+// Removes the expired entry
+// and calls the expiry callback
+func (t *ExpiringAVLTree[TKey, TValue]) expire(key TKey, entry expiringEntry[TKey, TValue]) {
+	heap.Remove(&t.expiries, entry.item.index)
+	t.tree.Remove(key)
+
+	if t.onExpire != nil {
+		t.onExpire(key, entry.value)
+	}
+}
+
+// NewExpiringAVLTree creates a new empty
+// expiring AVL tree with the specified options.
+// It's the only way to create a usable tree.
+func NewExpiringAVLTree[
+	TKey constraints.Ordered, TValue any,
+](
+	options ...ExpiringAVLTreeOption[TKey, TValue],
+) (
+	*ExpiringAVLTree[TKey, TValue], error,
+) {
+	tree := &ExpiringAVLTree[TKey, TValue]{
+		now: time.Now,
+	}
+
+	for i := 0; i < len(options); i++ {
+		option := options[i]
+		err := option(tree)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tree, nil
+}
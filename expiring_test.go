@@ -0,0 +1,150 @@
+package avltree_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zergon321/go-avltree"
+)
+
+// fakeClock is advanced
+// manually by the tests.
+type fakeClock struct {
+	now time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+func newExpiringTree(t *testing.T, clock *fakeClock, expired map[int]int) *avltree.ExpiringAVLTree[int, int] {
+	tree, err := avltree.NewExpiringAVLTree(
+		avltree.ExpiringAVLTreeOptionWithClock[int, int](clock.Now),
+		avltree.ExpiringAVLTreeOptionOnExpire(func(key, value int) {
+			expired[key] = value
+		}),
+	)
+	assert.Nil(t, err)
+
+	return tree
+}
+
+func TestExpiringAVLTree(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	expired := map[int]int{}
+	tree := newExpiringTree(t, clock, expired)
+
+	tree.Add(1, 10, time.Second)
+	tree.Add(2, 20, 2*time.Second)
+	tree.Add(3, 30, 0)
+	tree.Add(4, 40, time.Second)
+
+	value, ok := tree.Search(1)
+	assert.True(t, ok)
+	assert.Equal(t, 10, value)
+
+	deadline, ok := tree.Deadline(2)
+	assert.True(t, ok)
+	assert.Equal(t, clock.now.Add(2*time.Second), deadline)
+
+	// the entries are invisible as soon
+	// as they expire, before the sweep
+	clock.now = clock.now.Add(time.Second)
+	_, ok = tree.Search(1)
+	assert.False(t, ok)
+	assert.Equal(t, map[int]int{1: 10}, expired)
+	assert.Equal(t, 3, tree.Len())
+
+	keys := []int{}
+	tree.VisitInOrder(func(key, value int) error {
+		keys = append(keys, key)
+		return nil
+	})
+	assert.Equal(t, []int{2, 3}, keys)
+
+	// extending the ttl
+	tree.Add(2, 21, 5*time.Second)
+	assert.Equal(t, 1, tree.Sweep(clock.now.Add(2*time.Second)))
+	assert.Equal(t, map[int]int{1: 10, 4: 40}, expired)
+
+	value, ok = tree.Search(2)
+	assert.True(t, ok)
+	assert.Equal(t, 21, value)
+
+	// removing the ttl
+	tree.Add(2, 22, 0)
+	assert.Zero(t, tree.Sweep(clock.now.Add(time.Hour)))
+	assert.True(t, tree.Remove(2))
+	assert.False(t, tree.Remove(2))
+	assert.Equal(t, 1, tree.Len())
+	assert.Nil(t, tree.Validate())
+}
+
+func TestExpiringAVLTreeRandom(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	expired := map[int]int{}
+	tree := newExpiringTree(t, clock, expired)
+
+	type entry struct {
+		value    int
+		deadline time.Time
+	}
+
+	ref := map[int]entry{}
+
+	for i := 0; i < 5000; i++ {
+		key := rand.Intn(200)
+		clock.now = clock.now.Add(time.Duration(rand.Intn(100)) * time.Millisecond)
+
+		switch rand.Intn(5) {
+		case 0:
+			tree.Remove(key)
+			delete(ref, key)
+
+		case 1:
+			tree.Sweep(clock.now)
+
+		case 2:
+			value, ok := tree.Search(key)
+			expected, exists := ref[key]
+			exists = exists && (expected.deadline.IsZero() || clock.now.Before(expected.deadline))
+
+			assert.Equal(t, exists, ok)
+
+			if exists {
+				assert.Equal(t, expected.value, value)
+			}
+
+		default:
+			ttl := time.Duration(rand.Intn(3)) * time.Second
+			tree.Add(key, i, ttl)
+			ref[key] = entry{value: i}
+
+			if ttl > 0 {
+				ref[key] = entry{value: i, deadline: clock.now.Add(ttl)}
+			}
+		}
+
+		if err := tree.Validate(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+
+	tree.Sweep(clock.now)
+
+	for key, expected := range ref {
+		if !expected.deadline.IsZero() && !clock.now.Before(expected.deadline) {
+			delete(ref, key)
+		}
+	}
+
+	assert.Equal(t, len(ref), tree.Len())
+}
+
+func TestExpiringAVLTreeNilClock(t *testing.T) {
+	_, err := avltree.NewExpiringAVLTree(
+		avltree.ExpiringAVLTreeOptionWithClock[int, int](nil))
+	assert.IsType(t, &avltree.ErrorNilClock{}, err)
+}
@@ -0,0 +1,19 @@
+package avltree
+
+// SetHeight overrides the stored height
+// of the node to simulate a corrupted tree.
+func (node *AVLNode[TKey, TValue]) SetHeight(height int) {
+	node.height = height
+}
+
+// SetLeft overrides the left child
+// of the node to simulate a corrupted tree.
+func (node *AVLNode[TKey, TValue]) SetLeft(left *AVLNode[TKey, TValue]) {
+	node.left = left
+}
+
+// SetSize overrides the stored size
+// of the tree to simulate a corrupted tree.
+func (t *ArenaAVLTree[TKey, TValue]) SetSize(size int) {
+	t.size = size
+}
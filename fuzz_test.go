@@ -0,0 +1,402 @@
+package avltree_test
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/zergon321/go-avltree"
+	"github.com/zergon321/mempool"
+)
+
+// IntKey is an integer key
+// for the unrestricted tree.
+type IntKey int
+
+func (k IntKey) Less(other avltree.Comparable) bool {
+	return k < other.(IntKey)
+}
+
+func (k IntKey) Greater(other avltree.Comparable) bool {
+	return k > other.(IntKey)
+}
+
+func (k IntKey) Equal(other avltree.Comparable) bool {
+	return k == other.(IntKey)
+}
+
+// errFuzz is returned by the
+// update callbacks made to fail.
+var errFuzz = errors.New("fuzz")
+
+// fuzzSubject is a common interface
+// for all the tree types under test.
+type fuzzSubject interface {
+	Add(key, value int)
+	AddOrUpdate(key, value int, upd func(oldValue int) (int, error)) error
+	Remove(key int)
+	Update(oldKey, newKey, newValue int) bool
+	Search(key int) (int, bool)
+	Entries() []fuzzEntry
+	Validate() error
+}
+
+// fuzzEntry is a key-value
+// pair of the tree under test.
+type fuzzEntry struct {
+	key, value int
+}
+
+type avlSubject struct {
+	tree *avltree.AVLTree[int, int]
+}
+
+func (s avlSubject) Add(key, value int) {
+	s.tree.Add(key, value)
+}
+
+func (s avlSubject) AddOrUpdate(key, value int, upd func(oldValue int) (int, error)) error {
+	return s.tree.AddOrUpdate(key, value, upd)
+}
+
+func (s avlSubject) Remove(key int) {
+	s.tree.Remove(key)
+}
+
+func (s avlSubject) Update(oldKey, newKey, newValue int) bool {
+	return s.tree.Update(oldKey, newKey, newValue)
+}
+
+func (s avlSubject) Search(key int) (int, bool) {
+	node := s.tree.Search(key)
+
+	if node == nil {
+		return 0, false
+	}
+
+	return node.Value, true
+}
+
+func (s avlSubject) Entries() []fuzzEntry {
+	entries := []fuzzEntry{}
+	s.tree.VisitInOrder(func(node *avltree.AVLNode[int, int]) error {
+		entries = append(entries, fuzzEntry{key: node.Key(), value: node.Value})
+		return nil
+	})
+
+	return entries
+}
+
+func (s avlSubject) Validate() error {
+	return s.tree.Validate()
+}
+
+type unrestrictedSubject struct {
+	tree *avltree.UnrestrictedAVLTree[IntKey, int]
+}
+
+func (s unrestrictedSubject) Add(key, value int) {
+	s.tree.Add(IntKey(key), value)
+}
+
+func (s unrestrictedSubject) AddOrUpdate(key, value int, upd func(oldValue int) (int, error)) error {
+	return s.tree.AddOrUpdate(IntKey(key), value, upd)
+}
+
+func (s unrestrictedSubject) Remove(key int) {
+	s.tree.Remove(IntKey(key))
+}
+
+func (s unrestrictedSubject) Update(oldKey, newKey, newValue int) bool {
+	return s.tree.Update(IntKey(oldKey), IntKey(newKey), newValue)
+}
+
+func (s unrestrictedSubject) Search(key int) (int, bool) {
+	node := s.tree.Search(IntKey(key))
+
+	if node == nil {
+		return 0, false
+	}
+
+	return node.Value, true
+}
+
+func (s unrestrictedSubject) Entries() []fuzzEntry {
+	entries := []fuzzEntry{}
+	s.tree.VisitInOrder(func(node *avltree.UnrestrictedAVLNode[IntKey, int]) error {
+		entries = append(entries, fuzzEntry{key: int(node.Key()), value: node.Value})
+		return nil
+	})
+
+	return entries
+}
+
+func (s unrestrictedSubject) Validate() error {
+	return s.tree.Validate()
+}
+
+// oracle is a reference ordered map
+// the trees are compared against.
+type oracle struct {
+	values map[int]int
+}
+
+func (o *oracle) entries() []fuzzEntry {
+	keys := make([]int, 0, len(o.values))
+
+	for key := range o.values {
+		keys = append(keys, key)
+	}
+
+	sort.Ints(keys)
+	entries := make([]fuzzEntry, len(keys))
+
+	for i, key := range keys {
+		entries[i] = fuzzEntry{key: key, value: o.values[key]}
+	}
+
+	return entries
+}
+
+// runOperations interprets the data as a sequence
+// of tree operations, applies them both to the subject
+// and to the oracle and compares the results after each step.
+func runOperations(t *testing.T, subject fuzzSubject, data []byte) {
+	ref := &oracle{values: map[int]int{}}
+
+	for i := 0; i+3 < len(data); i += 4 {
+		step := i / 4
+		op := data[i] % 5
+		key := int(data[i+1] % 64)
+		value := int(data[i+2])
+		aux := int(data[i+3])
+
+		switch op {
+		case 0:
+			subject.Add(key, value)
+			ref.values[key] = value
+
+		case 1:
+			// the update fails now and then
+			// and must leave the tree unchanged
+			fail := aux%4 == 0
+			upd := func(oldValue int) (int, error) {
+				if fail {
+					return 0, errFuzz
+				}
+
+				return oldValue + value, nil
+			}
+
+			err := subject.AddOrUpdate(key, value, upd)
+			oldValue, ok := ref.values[key]
+
+			switch {
+			case ok && fail:
+				if err != errFuzz {
+					t.Fatalf("step %d: AddOrUpdate(%d) = %v, expected %v", step, key, err, errFuzz)
+				}
+
+			case err != nil:
+				t.Fatalf("step %d: AddOrUpdate(%d) failed: %v", step, key, err)
+
+			case ok:
+				ref.values[key] = oldValue + value
+
+			default:
+				ref.values[key] = value
+			}
+
+		case 2:
+			subject.Remove(key)
+			delete(ref.values, key)
+
+		case 3:
+			oldKey := aux % 64
+			existed := subject.Update(oldKey, key, value)
+			_, refExisted := ref.values[oldKey]
+
+			if existed != refExisted {
+				t.Fatalf("step %d: Update(%d, %d) = %t, expected %t",
+					step, oldKey, key, existed, refExisted)
+			}
+
+			if refExisted {
+				delete(ref.values, oldKey)
+				ref.values[key] = value
+			}
+
+		case 4:
+			value, ok := subject.Search(key)
+			refValue, refOk := ref.values[key]
+
+			if ok != refOk || value != refValue {
+				t.Fatalf("step %d: Search(%d) = (%d, %t), expected (%d, %t)",
+					step, key, value, ok, refValue, refOk)
+			}
+		}
+
+		if err := subject.Validate(); err != nil {
+			t.Fatalf("step %d: %v", step, err)
+		}
+
+		entries := subject.Entries()
+		refEntries := ref.entries()
+
+		if len(entries) != len(refEntries) {
+			t.Fatalf("step %d: tree has %d entries, expected %d", step, len(entries), len(refEntries))
+		}
+
+		for j := range entries {
+			if entries[j] != refEntries[j] {
+				t.Fatalf("step %d: entry #%d is %v, expected %v", step, j, entries[j], refEntries[j])
+			}
+		}
+	}
+}
+
+func addSeeds(f *testing.F) {
+	rnd := rand.New(rand.NewSource(321))
+
+	for i := 0; i < 16; i++ {
+		data := make([]byte, 4*(64+rnd.Intn(256)))
+		rnd.Read(data)
+		f.Add(data)
+	}
+}
+
+func FuzzAVLTree(f *testing.F) {
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tree, err := avltree.NewAVLTree[int, int]()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		runOperations(t, avlSubject{tree: tree}, data)
+	})
+}
+
+func FuzzAVLTreeMemoryPool(f *testing.F) {
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pool, err := mempool.NewPool(func() *avltree.AVLNode[int, int] {
+			return &avltree.AVLNode[int, int]{}
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tree, err := avltree.NewAVLTree(avltree.AVLTreeOptionWithMemoryPool(pool))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		runOperations(t, avlSubject{tree: tree}, data)
+	})
+}
+
+func FuzzAVLTreeParentLinks(f *testing.F) {
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tree, err := avltree.NewAVLTree(
+			avltree.AVLTreeOptionWithParentLinks[int, int]())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		runOperations(t, avlSubject{tree: tree}, data)
+	})
+}
+
+func FuzzAVLTreeHashing(f *testing.F) {
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tree, err := avltree.NewAVLTree(
+			avltree.AVLTreeOptionWithHashing(encodeEntry))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		runOperations(t, avlSubject{tree: tree}, data)
+	})
+}
+
+func FuzzUnrestrictedAVLTree(f *testing.F) {
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tree, err := avltree.NewUnrestrictedAVLTree[IntKey, int]()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		runOperations(t, unrestrictedSubject{tree: tree}, data)
+	})
+}
+
+func FuzzUnrestrictedAVLTreeMemoryPool(f *testing.F) {
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pool, err := mempool.NewPool(func() *avltree.UnrestrictedAVLNode[IntKey, int] {
+			return &avltree.UnrestrictedAVLNode[IntKey, int]{}
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tree, err := avltree.NewUnrestrictedAVLTree(
+			avltree.UnrestrictedAVLTreeOptionWithMemoryPool(pool))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		runOperations(t, unrestrictedSubject{tree: tree}, data)
+	})
+}
+
+func FuzzUnrestrictedAVLTreeParentLinks(f *testing.F) {
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tree, err := avltree.NewUnrestrictedAVLTree(
+			avltree.UnrestrictedAVLTreeOptionWithParentLinks[IntKey, int]())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		runOperations(t, unrestrictedSubject{tree: tree}, data)
+	})
+}
+
+func FuzzUnrestrictedAVLTreeHashing(f *testing.F) {
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tree, err := avltree.NewUnrestrictedAVLTree(
+			avltree.UnrestrictedAVLTreeOptionWithHashing(func(key IntKey, value int) []byte {
+				return encodeEntry(int(key), value)
+			}))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		runOperations(t, unrestrictedSubject{tree: tree}, data)
+	})
+}
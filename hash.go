@@ -0,0 +1,345 @@
+package avltree
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// Hash summarises the entries of a tree
+// or a range of keys. It doesn't depend
+// on the shape of the tree, so the trees
+// holding the same entries have equal hashes.
+type Hash [sha256.Size]byte
+
+// hashSum is a sum of entry hashes
+// split into 64-bit lanes. The sum is
+// commutative, so it doesn't depend on
+// the shape of the subtree.
+type hashSum [sha256.Size / 8]uint64
+
+func (sum hashSum) add(other hashSum) hashSum {
+	for i := range sum {
+		sum[i] += other[i]
+	}
+
+	return sum
+}
+
+func (sum hashSum) sub(other hashSum) hashSum {
+	for i := range sum {
+		sum[i] -= other[i]
+	}
+
+	return sum
+}
+
+func (sum hashSum) bytes() Hash {
+	var hash Hash
+
+	for i := range sum {
+		binary.LittleEndian.PutUint64(hash[i*8:], sum[i])
+	}
+
+	return hash
+}
+
+// entryHash hashes the encoded entry
+func entryHash(data []byte) hashSum {
+	var sum hashSum
+	digest := sha256.Sum256(data)
+
+	for i := range sum {
+		sum[i] = binary.LittleEndian.Uint64(digest[i*8:])
+	}
+
+	return sum
+}
+
+// nodeHash is the hash
+// augmentation of a node.
+type nodeHash struct {
+	// entry is the hash of the
+	// key and the value of the node.
+	entry hashSum
+	// subtree is the sum of the
+	// entry hashes of the subtree.
+	subtree hashSum
+}
+
+// RootHash returns the hash of all the entries of the tree.
+// The hash is the sum of the SHA-256 hashes of the
+// entries, so it doesn't depend on the tree shape or
+// the order of the writes. It's a checksum to compare
+// trees and detect accidental differences, not
+// a cryptographic commitment: whoever chooses the
+// entries can find a different set with the same
+// hash, so it must not be used for tamper-evidence.
+// The tree must be created with hashing enabled.
+func (t *AVLTree[TKey, TValue]) RootHash() (Hash, error) {
+	if t.encode == nil {
+		return Hash{}, &ErrorHashingDisabled{}
+	}
+
+	return t.root.subtreeHash().bytes(), nil
+}
+
+// RangeHash returns the hash of the entries with
+// lo <= key < hi. A nil bound leaves the range
+// open on its side. It takes O(log n) time.
+// It's the same kind of checksum as RootHash.
+// The tree must be created with hashing enabled.
+func (t *AVLTree[TKey, TValue]) RangeHash(lo, hi *TKey) (Hash, error) {
+	if t.encode == nil {
+		return Hash{}, &ErrorHashingDisabled{}
+	}
+
+	if lo != nil && hi != nil && *lo >= *hi {
+		return Hash{}, nil
+	}
+
+	sum := t.root.subtreeHash()
+
+	if hi != nil {
+		sum = t.prefixHash(*hi)
+	}
+
+	if lo != nil {
+		sum = sum.sub(t.prefixHash(*lo))
+	}
+
+	return sum.bytes(), nil
+}
+
+// SplitKey returns the key of the topmost node with
+// lo < key < hi. A nil bound leaves the range open
+// on its side. The node is an ancestor of all the
+// other nodes in the range, so splitting the range
+// [lo, hi) into [lo, key) and [key, hi) makes the
+// next split keys come from the deeper levels.
+// Comparing RangeHash of both parts with a replica
+// and descending into the differing ones locates
+// each differing range in O(log n) round trips.
+// Returns false if there's no such key.
+func (t *AVLTree[TKey, TValue]) SplitKey(lo, hi *TKey) (TKey, bool) {
+	node := t.root
+
+	for node != nil {
+		if lo != nil && node.key <= *lo {
+			node = node.right
+		} else if hi != nil && node.key >= *hi {
+			node = node.left
+		} else {
+			return node.key, true
+		}
+	}
+
+	var zeroValTKey TKey
+
+	return zeroValTKey, false
+}
+
+// Sums the hashes of the entries with keys less than the bound
+func (t *AVLTree[TKey, TValue]) prefixHash(bound TKey) hashSum {
+	var sum hashSum
+	node := t.root
+
+	for node != nil {
+		if node.key < bound {
+			sum = sum.add(node.hash.entry).add(node.left.subtreeHash())
+			node = node.right
+		} else {
+			node = node.left
+		}
+	}
+
+	return sum
+}
+
+// Sets the value of the node referenced by the slot and
+// recalculates the hashes up to the root if hashing is enabled
+func (t *AVLTree[TKey, TValue]) setValue(path []**AVLNode[TKey, TValue], slot **AVLNode[TKey, TValue], value TValue) {
+	node := *slot
+	oldValue := node.Value
+	node.Value = value
+	t.notify(EventUpdate, node.key, oldValue, value)
+
+	if t.encode == nil {
+		return
+	}
+
+	t.hashEntry(node)
+
+	for i := len(path) - 1; i >= 0; i-- {
+		(*path[i]).recalculateHash()
+	}
+}
+
+// Recalculates the hash of the node's entry
+// and subtree if hashing is enabled
+func (t *AVLTree[TKey, TValue]) hashEntry(node *AVLNode[TKey, TValue]) {
+	if t.encode == nil {
+		return
+	}
+
+	if node.hash == nil {
+		node.hash = &nodeHash{}
+	}
+
+	node.hash.entry = entryHash(t.encode(node.key, node.Value))
+	node.recalculateHash()
+}
+
+func (n *AVLNode[TKey, TValue]) subtreeHash() hashSum {
+	if n == nil || n.hash == nil {
+		return hashSum{}
+	}
+
+	return n.hash.subtree
+}
+
+func (n *AVLNode[TKey, TValue]) recalculateHash() {
+	if n.hash == nil {
+		return
+	}
+
+	n.hash.subtree = n.hash.entry.add(n.left.subtreeHash()).add(n.right.subtreeHash())
+}
+
+// RootHash returns the hash of all the entries of the tree.
+// The hash is the sum of the SHA-256 hashes of the
+// entries, so it doesn't depend on the tree shape or
+// the order of the writes. It's a checksum to compare
+// trees and detect accidental differences, not
+// a cryptographic commitment: whoever chooses the
+// entries can find a different set with the same
+// hash, so it must not be used for tamper-evidence.
+// The tree must be created with hashing enabled.
+func (t *UnrestrictedAVLTree[TKey, TValue]) RootHash() (Hash, error) {
+	if t.encode == nil {
+		return Hash{}, &ErrorHashingDisabled{}
+	}
+
+	return t.root.subtreeHash().bytes(), nil
+}
+
+// RangeHash returns the hash of the entries with
+// lo <= key < hi. A nil bound leaves the range
+// open on its side. It takes O(log n) time.
+// It's the same kind of checksum as RootHash.
+// The tree must be created with hashing enabled.
+func (t *UnrestrictedAVLTree[TKey, TValue]) RangeHash(lo, hi *TKey) (Hash, error) {
+	if t.encode == nil {
+		return Hash{}, &ErrorHashingDisabled{}
+	}
+
+	if lo != nil && hi != nil && !(*lo).Less(*hi) {
+		return Hash{}, nil
+	}
+
+	sum := t.root.subtreeHash()
+
+	if hi != nil {
+		sum = t.prefixHash(*hi)
+	}
+
+	if lo != nil {
+		sum = sum.sub(t.prefixHash(*lo))
+	}
+
+	return sum.bytes(), nil
+}
+
+// SplitKey returns the key of the topmost node with
+// lo < key < hi. A nil bound leaves the range open
+// on its side. The node is an ancestor of all the
+// other nodes in the range, so splitting the range
+// [lo, hi) into [lo, key) and [key, hi) makes the
+// next split keys come from the deeper levels.
+// Comparing RangeHash of both parts with a replica
+// and descending into the differing ones locates
+// each differing range in O(log n) round trips.
+// Returns false if there's no such key.
+func (t *UnrestrictedAVLTree[TKey, TValue]) SplitKey(lo, hi *TKey) (TKey, bool) {
+	node := t.root
+
+	for node != nil {
+		if lo != nil && !(*lo).Less(node.key) {
+			node = node.right
+		} else if hi != nil && !node.key.Less(*hi) {
+			node = node.left
+		} else {
+			return node.key, true
+		}
+	}
+
+	var zeroValTKey TKey
+
+	return zeroValTKey, false
+}
+
+// Sums the hashes of the entries with keys less than the bound
+func (t *UnrestrictedAVLTree[TKey, TValue]) prefixHash(bound TKey) hashSum {
+	var sum hashSum
+	node := t.root
+
+	for node != nil {
+		if node.key.Less(bound) {
+			sum = sum.add(node.hash.entry).add(node.left.subtreeHash())
+			node = node.right
+		} else {
+			node = node.left
+		}
+	}
+
+	return sum
+}
+
+// Sets the value of the node referenced by the slot and
+// recalculates the hashes up to the root if hashing is enabled
+func (t *UnrestrictedAVLTree[TKey, TValue]) setValue(path []**UnrestrictedAVLNode[TKey, TValue], slot **UnrestrictedAVLNode[TKey, TValue], value TValue) {
+	node := *slot
+	oldValue := node.Value
+	node.Value = value
+	t.notify(EventUpdate, node.key, oldValue, value)
+
+	if t.encode == nil {
+		return
+	}
+
+	t.hashEntry(node)
+
+	for i := len(path) - 1; i >= 0; i-- {
+		(*path[i]).recalculateHash()
+	}
+}
+
+// Recalculates the hash of the node's entry
+// and subtree if hashing is enabled
+func (t *UnrestrictedAVLTree[TKey, TValue]) hashEntry(node *UnrestrictedAVLNode[TKey, TValue]) {
+	if t.encode == nil {
+		return
+	}
+
+	if node.hash == nil {
+		node.hash = &nodeHash{}
+	}
+
+	node.hash.entry = entryHash(t.encode(node.key, node.Value))
+	node.recalculateHash()
+}
+
+func (n *UnrestrictedAVLNode[TKey, TValue]) subtreeHash() hashSum {
+	if n == nil || n.hash == nil {
+		return hashSum{}
+	}
+
+	return n.hash.subtree
+}
+
+func (n *UnrestrictedAVLNode[TKey, TValue]) recalculateHash() {
+	if n.hash == nil {
+		return
+	}
+
+	n.hash.subtree = n.hash.entry.add(n.left.subtreeHash()).add(n.right.subtreeHash())
+}
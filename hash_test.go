@@ -0,0 +1,180 @@
+package avltree_test
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zergon321/go-avltree"
+	"github.com/zergon321/mempool"
+)
+
+func encodeEntry(key, value int) []byte {
+	data := make([]byte, 16)
+	binary.LittleEndian.PutUint64(data, uint64(key))
+	binary.LittleEndian.PutUint64(data[8:], uint64(value))
+
+	return data
+}
+
+func newHashedTree(t *testing.T) *avltree.AVLTree[int, int] {
+	tree, err := avltree.NewAVLTree(
+		avltree.AVLTreeOptionWithHashing(encodeEntry))
+	assert.Nil(t, err)
+
+	return tree
+}
+
+func TestRootHash(t *testing.T) {
+	tree := newHashedTree(t)
+	ref := map[int]int{}
+
+	for i := 0; i < 2000; i++ {
+		key := rand.Intn(300)
+		value := rand.Intn(10)
+
+		switch rand.Intn(6) {
+		case 0, 1:
+			tree.Add(key, value)
+			ref[key] = value
+
+		case 2:
+			tree.Remove(key)
+			delete(ref, key)
+
+		case 3:
+			if tree.Update(key, key+1, value) {
+				delete(ref, key)
+				ref[key+1] = value
+			}
+
+		case 4:
+			err := tree.AddOrUpdate(key, value, func(oldValue int) (int, error) {
+				return oldValue + value, nil
+			})
+			assert.Nil(t, err)
+			ref[key] += value
+
+		case 5:
+			tree.RemoveRange(key, key+3)
+
+			for k := key; k <= key+3; k++ {
+				delete(ref, k)
+			}
+		}
+
+		assert.Nil(t, tree.Validate())
+	}
+
+	// the tree of the same entries built
+	// in another order has the same hash
+	other := newHashedTree(t)
+
+	for key, value := range ref {
+		other.Add(key, value)
+	}
+
+	hash, err := tree.RootHash()
+	assert.Nil(t, err)
+	otherHash, err := other.RootHash()
+	assert.Nil(t, err)
+	assert.Equal(t, hash, otherHash)
+
+	other.Add(1000, 0)
+	otherHash, err = other.RootHash()
+	assert.Nil(t, err)
+	assert.NotEqual(t, hash, otherHash)
+
+	_, err = (&avltree.AVLTree[int, int]{}).RootHash()
+	assert.ErrorIs(t, err, &avltree.ErrorHashingDisabled{})
+}
+
+func TestRangeHashLocatesDifference(t *testing.T) {
+	tree := newHashedTree(t)
+	replica := newHashedTree(t)
+
+	for i := 0; i < 1000; i++ {
+		tree.Add(i*2, i)
+		replica.Add(i*2, i)
+	}
+
+	replica.Add(701, 0)
+	replica.Add(1200, -1)
+
+	type keyRange struct {
+		lo, hi *int
+	}
+
+	ranges := []keyRange{{}}
+	differing := []keyRange{}
+	rounds := 0
+
+	for len(ranges) > 0 {
+		rounds++
+		next := []keyRange{}
+
+		for _, r := range ranges {
+			hash, err := tree.RangeHash(r.lo, r.hi)
+			assert.Nil(t, err)
+			replicaHash, err := replica.RangeHash(r.lo, r.hi)
+			assert.Nil(t, err)
+
+			if hash == replicaHash {
+				continue
+			}
+
+			key, ok := tree.SplitKey(r.lo, r.hi)
+
+			if !ok {
+				differing = append(differing, r)
+				continue
+			}
+
+			next = append(next, keyRange{r.lo, &key}, keyRange{&key, r.hi})
+		}
+
+		ranges = next
+	}
+
+	assert.LessOrEqual(t, rounds, 12)
+	assert.Len(t, differing, 2)
+	assert.Equal(t, 700, *differing[0].lo)
+	assert.Equal(t, 702, *differing[0].hi)
+	assert.Equal(t, 1200, *differing[1].lo)
+	assert.Equal(t, 1202, *differing[1].hi)
+}
+
+func TestRootHashPooledNodes(t *testing.T) {
+	pool, err := mempool.NewPool(func() *avltree.AVLNode[int, int] {
+		return &avltree.AVLNode[int, int]{}
+	})
+	assert.Nil(t, err)
+
+	tree, err := avltree.NewAVLTree(
+		avltree.AVLTreeOptionWithHashing(encodeEntry),
+		avltree.AVLTreeOptionWithMemoryPool(pool))
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		tree.Add(i, i)
+	}
+
+	tree.Clear()
+
+	// the nodes reused from the pool
+	// must not carry the old hashes
+	fresh := newHashedTree(t)
+
+	for i := 0; i < 50; i++ {
+		tree.Add(i*3, i)
+		fresh.Add(i*3, i)
+	}
+
+	expected, err := fresh.RootHash()
+	assert.Nil(t, err)
+	actual, err := tree.RootHash()
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+	assert.Nil(t, tree.Validate())
+}
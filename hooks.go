@@ -0,0 +1,58 @@
+package avltree
+
+// hooks are the functions called by the tree
+// on its changes. A tree without hooks keeps
+// the pointer to them nil.
+type hooks[TKey any, TValue any] struct {
+	onInsert []func(key TKey, value TValue)
+	onUpdate []func(key TKey, oldValue, newValue TValue)
+	onDelete []func(key TKey, value TValue)
+	onRotate []func(pivot, newRoot TKey)
+}
+
+// Calls the hooks registered for the change
+func (h *hooks[TKey, TValue]) changed(kind EventKind, key TKey, oldValue, newValue TValue) {
+	switch kind {
+	case EventInsert:
+		for _, hook := range h.onInsert {
+			hook(key, newValue)
+		}
+
+	case EventUpdate:
+		for _, hook := range h.onUpdate {
+			hook(key, oldValue, newValue)
+		}
+
+	case EventDelete:
+		for _, hook := range h.onDelete {
+			hook(key, oldValue)
+		}
+	}
+}
+
+// Calls the hooks registered for the rotations
+func (h *hooks[TKey, TValue]) rotated(pivot, newRoot TKey) {
+	for _, hook := range h.onRotate {
+		hook(pivot, newRoot)
+	}
+}
+
+// Returns the hooks of the tree
+// creating them if there are none
+func (t *AVLTree[TKey, TValue]) hookSet() *hooks[TKey, TValue] {
+	if t.hooks == nil {
+		t.hooks = &hooks[TKey, TValue]{}
+	}
+
+	return t.hooks
+}
+
+// Returns the hooks of the tree
+// creating them if there are none
+func (t *UnrestrictedAVLTree[TKey, TValue]) hookSet() *hooks[TKey, TValue] {
+	if t.hooks == nil {
+		t.hooks = &hooks[TKey, TValue]{}
+	}
+
+	return t.hooks
+}
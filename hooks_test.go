@@ -0,0 +1,96 @@
+package avltree_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zergon321/go-avltree"
+)
+
+func TestHooks(t *testing.T) {
+	// the secondary index maps
+	// the values to the keys
+	index := map[int]int{}
+	rotations := 0
+
+	tree, err := avltree.NewAVLTree(
+		avltree.AVLTreeOptionOnInsert(func(key, value int) {
+			index[value] = key
+		}),
+		avltree.AVLTreeOptionOnUpdate(func(key, oldValue, newValue int) {
+			delete(index, oldValue)
+			index[newValue] = key
+		}),
+		avltree.AVLTreeOptionOnDelete(func(key, value int) {
+			delete(index, value)
+		}),
+		avltree.AVLTreeOptionOnRotate[int, int](func(pivot, newRoot int) {
+			rotations++
+		}),
+	)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		tree.Add(i, -i)
+	}
+
+	// sequential inserts rotate
+	// at every power of 2
+	assert.Greater(t, rotations, 0)
+
+	for i := 0; i < 100; i += 2 {
+		tree.Remove(i)
+	}
+
+	for i := 1; i < 100; i += 4 {
+		tree.Add(i, -i-1000)
+	}
+
+	err = tree.AddOrUpdate(3, 0, func(oldValue int) (int, error) {
+		return oldValue - 2000, nil
+	})
+	assert.Nil(t, err)
+
+	expected := map[int]int{}
+	tree.VisitInOrder(func(node *avltree.AVLNode[int, int]) error {
+		expected[node.Value] = node.Key()
+		return nil
+	})
+
+	assert.Equal(t, expected, index)
+
+	tree.Clear()
+	assert.Empty(t, index)
+}
+
+func TestUnrestrictedHooks(t *testing.T) {
+	inserts, updates, deletes := 0, 0, 0
+
+	tree, err := avltree.NewUnrestrictedAVLTree(
+		avltree.UnrestrictedAVLTreeOptionOnInsert(func(key IntKey, value int) {
+			inserts++
+		}),
+		avltree.UnrestrictedAVLTreeOptionOnUpdate(func(key IntKey, oldValue, newValue int) {
+			updates++
+		}),
+		avltree.UnrestrictedAVLTreeOptionOnDelete(func(key IntKey, value int) {
+			deletes++
+		}),
+		avltree.UnrestrictedAVLTreeOptionOnDelete(func(key IntKey, value int) {
+			deletes++
+		}),
+	)
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		tree.Add(IntKey(i), i)
+	}
+
+	tree.Add(IntKey(5), 0)
+	tree.Remove(IntKey(7))
+	tree.RemoveRange(IntKey(0), IntKey(2))
+
+	assert.Equal(t, 10, inserts)
+	assert.Equal(t, 1, updates)
+	assert.Equal(t, 2*4, deletes)
+}
@@ -1,6 +1,8 @@
 package avltree
 
 import (
+	"time"
+
 	"github.com/zergon321/mempool"
 	"golang.org/x/exp/constraints"
 )
@@ -15,7 +17,186 @@ func AVLTreeOptionWithMemoryPool[
 	pool *mempool.Pool[*AVLNode[TKey, TValue]],
 ) AVLTreeOption[TKey, TValue] {
 	return func(tree *AVLTree[TKey, TValue]) error {
-		tree.pool = pool
+		tree.pool = MempoolAllocator(pool)
+		return nil
+	}
+}
+
+// AVLTreeOptionWithAllocator makes the tree
+// take the nodes from the allocator.
+func AVLTreeOptionWithAllocator[
+	TKey constraints.Ordered, TValue any,
+](
+	alloc NodeAllocator[*AVLNode[TKey, TValue]],
+) AVLTreeOption[TKey, TValue] {
+	return func(tree *AVLTree[TKey, TValue]) error {
+		tree.pool = alloc
+		return nil
+	}
+}
+
+// AVLTreeOptionWithParentLinks makes the tree
+// maintain parent pointers in the nodes so
+// Next and Prev can be called on them.
+func AVLTreeOptionWithParentLinks[
+	TKey constraints.Ordered, TValue any,
+]() AVLTreeOption[TKey, TValue] {
+	return func(tree *AVLTree[TKey, TValue]) error {
+		tree.parentLinks = true
+		return nil
+	}
+}
+
+// AVLTreeOptionWithHashing makes the nodes maintain
+// the hashes of their subtrees over the entries
+// encoded by encode, so RootHash and RangeHash
+// can be used. The values must only be changed
+// by the tree methods to keep the hashes valid.
+func AVLTreeOptionWithHashing[
+	TKey constraints.Ordered, TValue any,
+](
+	encode func(key TKey, value TValue) []byte,
+) AVLTreeOption[TKey, TValue] {
+	return func(tree *AVLTree[TKey, TValue]) error {
+		tree.encode = encode
+		return nil
+	}
+}
+
+// AVLTreeOptionOnInsert registers the hook
+// called after a key is added to the tree.
+func AVLTreeOptionOnInsert[
+	TKey constraints.Ordered, TValue any,
+](
+	hook func(key TKey, value TValue),
+) AVLTreeOption[TKey, TValue] {
+	return func(tree *AVLTree[TKey, TValue]) error {
+		hooks := tree.hookSet()
+		hooks.onInsert = append(hooks.onInsert, hook)
+
+		return nil
+	}
+}
+
+// AVLTreeOptionOnUpdate registers the hook
+// called after the value of a key is changed.
+func AVLTreeOptionOnUpdate[
+	TKey constraints.Ordered, TValue any,
+](
+	hook func(key TKey, oldValue, newValue TValue),
+) AVLTreeOption[TKey, TValue] {
+	return func(tree *AVLTree[TKey, TValue]) error {
+		hooks := tree.hookSet()
+		hooks.onUpdate = append(hooks.onUpdate, hook)
+
+		return nil
+	}
+}
+
+// AVLTreeOptionOnDelete registers the hook
+// called after a key is removed from the tree.
+func AVLTreeOptionOnDelete[
+	TKey constraints.Ordered, TValue any,
+](
+	hook func(key TKey, value TValue),
+) AVLTreeOption[TKey, TValue] {
+	return func(tree *AVLTree[TKey, TValue]) error {
+		hooks := tree.hookSet()
+		hooks.onDelete = append(hooks.onDelete, hook)
+
+		return nil
+	}
+}
+
+// AVLTreeOptionOnRotate registers the hook called
+// after the subtree of the pivot is rotated and
+// the child newRoot takes the place of the pivot.
+func AVLTreeOptionOnRotate[
+	TKey constraints.Ordered, TValue any,
+](
+	hook func(pivot, newRoot TKey),
+) AVLTreeOption[TKey, TValue] {
+	return func(tree *AVLTree[TKey, TValue]) error {
+		hooks := tree.hookSet()
+		hooks.onRotate = append(hooks.onRotate, hook)
+
+		return nil
+	}
+}
+
+// AVLTreeOptionWithMaxSize limits the number of entries
+// in the tree. Once an insert exceeds the limit, an entry
+// is evicted by the policy within the same call.
+// With EvictLRU the searches change the tree, so
+// they must be locked exclusively like the writes.
+func AVLTreeOptionWithMaxSize[
+	TKey constraints.Ordered, TValue any,
+](
+	maxSize int, policy EvictionPolicy,
+) AVLTreeOption[TKey, TValue] {
+	return func(tree *AVLTree[TKey, TValue]) error {
+		if maxSize <= 0 {
+			return &ErrorNonPositiveMaxSize{
+				maxSize: maxSize,
+			}
+		}
+
+		switch policy {
+		case EvictSmallest, EvictLargest:
+
+		case EvictLRU:
+			tree.trackUsage()
+
+		default:
+			return &ErrorUnknownEvictionPolicy{
+				policy: policy,
+			}
+		}
+
+		bound := tree.sizeBound()
+		bound.maxSize = maxSize
+		bound.policy = policy
+
+		return nil
+	}
+}
+
+// AVLTreeOptionWithEvictionFunc limits the number of entries
+// in the tree. Once an insert exceeds the limit, the entry
+// of the key returned by choose is evicted. choose gets the
+// inserted key and must not write to the tree. If it returns
+// a key which isn't in the tree, the inserted entry is evicted.
+func AVLTreeOptionWithEvictionFunc[
+	TKey constraints.Ordered, TValue any,
+](
+	maxSize int, choose func(tree *AVLTree[TKey, TValue], key TKey) TKey,
+) AVLTreeOption[TKey, TValue] {
+	return func(tree *AVLTree[TKey, TValue]) error {
+		if maxSize <= 0 {
+			return &ErrorNonPositiveMaxSize{
+				maxSize: maxSize,
+			}
+		}
+
+		bound := tree.sizeBound()
+		bound.maxSize = maxSize
+		bound.choose = choose
+
+		return nil
+	}
+}
+
+// AVLTreeOptionOnEvict registers the hook called
+// after an entry is evicted by the size limit.
+// The delete hooks and watchers are notified
+// of the eviction as well.
+func AVLTreeOptionOnEvict[
+	TKey constraints.Ordered, TValue any,
+](
+	hook func(key TKey, value TValue),
+) AVLTreeOption[TKey, TValue] {
+	return func(tree *AVLTree[TKey, TValue]) error {
+		tree.sizeBound().onEvict = hook
 		return nil
 	}
 }
@@ -30,7 +211,337 @@ func UnrestrictedAVLTreeOptionWithMemoryPool[
 	pool *mempool.Pool[*UnrestrictedAVLNode[TKey, TValue]],
 ) UnrestrictedAVLTreeOption[TKey, TValue] {
 	return func(tree *UnrestrictedAVLTree[TKey, TValue]) error {
-		tree.pool = pool
+		tree.pool = MempoolAllocator(pool)
+		return nil
+	}
+}
+
+// UnrestrictedAVLTreeOptionWithAllocator makes
+// the tree take the nodes from the allocator.
+func UnrestrictedAVLTreeOptionWithAllocator[
+	TKey Comparable, TValue any,
+](
+	alloc NodeAllocator[*UnrestrictedAVLNode[TKey, TValue]],
+) UnrestrictedAVLTreeOption[TKey, TValue] {
+	return func(tree *UnrestrictedAVLTree[TKey, TValue]) error {
+		tree.pool = alloc
+		return nil
+	}
+}
+
+// UnrestrictedAVLTreeOptionWithParentLinks makes
+// the tree maintain parent pointers in the nodes
+// so Next and Prev can be called on them.
+func UnrestrictedAVLTreeOptionWithParentLinks[
+	TKey Comparable, TValue any,
+]() UnrestrictedAVLTreeOption[TKey, TValue] {
+	return func(tree *UnrestrictedAVLTree[TKey, TValue]) error {
+		tree.parentLinks = true
+		return nil
+	}
+}
+
+// UnrestrictedAVLTreeOptionWithHashing makes the
+// nodes maintain the hashes of their subtrees over
+// the entries encoded by encode, so RootHash and
+// RangeHash can be used. The values must only be
+// changed by the tree methods to keep the hashes valid.
+func UnrestrictedAVLTreeOptionWithHashing[
+	TKey Comparable, TValue any,
+](
+	encode func(key TKey, value TValue) []byte,
+) UnrestrictedAVLTreeOption[TKey, TValue] {
+	return func(tree *UnrestrictedAVLTree[TKey, TValue]) error {
+		tree.encode = encode
+		return nil
+	}
+}
+
+// UnrestrictedAVLTreeOptionOnInsert registers the hook
+// called after a key is added to the tree.
+func UnrestrictedAVLTreeOptionOnInsert[
+	TKey Comparable, TValue any,
+](
+	hook func(key TKey, value TValue),
+) UnrestrictedAVLTreeOption[TKey, TValue] {
+	return func(tree *UnrestrictedAVLTree[TKey, TValue]) error {
+		hooks := tree.hookSet()
+		hooks.onInsert = append(hooks.onInsert, hook)
+
+		return nil
+	}
+}
+
+// UnrestrictedAVLTreeOptionOnUpdate registers the hook
+// called after the value of a key is changed.
+func UnrestrictedAVLTreeOptionOnUpdate[
+	TKey Comparable, TValue any,
+](
+	hook func(key TKey, oldValue, newValue TValue),
+) UnrestrictedAVLTreeOption[TKey, TValue] {
+	return func(tree *UnrestrictedAVLTree[TKey, TValue]) error {
+		hooks := tree.hookSet()
+		hooks.onUpdate = append(hooks.onUpdate, hook)
+
+		return nil
+	}
+}
+
+// UnrestrictedAVLTreeOptionOnDelete registers the hook
+// called after a key is removed from the tree.
+func UnrestrictedAVLTreeOptionOnDelete[
+	TKey Comparable, TValue any,
+](
+	hook func(key TKey, value TValue),
+) UnrestrictedAVLTreeOption[TKey, TValue] {
+	return func(tree *UnrestrictedAVLTree[TKey, TValue]) error {
+		hooks := tree.hookSet()
+		hooks.onDelete = append(hooks.onDelete, hook)
+
+		return nil
+	}
+}
+
+// UnrestrictedAVLTreeOptionOnRotate registers the hook called
+// after the subtree of the pivot is rotated and
+// the child newRoot takes the place of the pivot.
+func UnrestrictedAVLTreeOptionOnRotate[
+	TKey Comparable, TValue any,
+](
+	hook func(pivot, newRoot TKey),
+) UnrestrictedAVLTreeOption[TKey, TValue] {
+	return func(tree *UnrestrictedAVLTree[TKey, TValue]) error {
+		hooks := tree.hookSet()
+		hooks.onRotate = append(hooks.onRotate, hook)
+
+		return nil
+	}
+}
+
+type ArenaAVLTreeOption[
+	TKey constraints.Ordered, TValue any,
+] func(tree *ArenaAVLTree[TKey, TValue]) error
+
+// ArenaAVLTreeOptionInitialCapacity preallocates
+// the arena for the specified number of nodes.
+func ArenaAVLTreeOptionInitialCapacity[
+	TKey constraints.Ordered, TValue any,
+](
+	capacity int,
+) ArenaAVLTreeOption[TKey, TValue] {
+	return func(tree *ArenaAVLTree[TKey, TValue]) error {
+		if capacity < 0 {
+			return &ErrorNegativeCapacity{
+				capacity: capacity,
+			}
+		}
+
+		// one more for the nil index
+		tree.nodes = make([]ArenaAVLNode[TKey, TValue], 0, capacity+1)
+
+		return nil
+	}
+}
+
+type ShardedAVLTreeOption[
+	TKey constraints.Ordered, TValue any,
+] func(tree *ShardedAVLTree[TKey, TValue]) error
+
+// ShardedAVLTreeOptionWithRebalancing sets when the shard
+// boundaries are moved: a shard of at least minSize keys
+// is rebalanced as soon as it holds more than ratio times
+// the average number of keys per shard. Zero ratio turns
+// the rebalancing off.
+func ShardedAVLTreeOptionWithRebalancing[
+	TKey constraints.Ordered, TValue any,
+](
+	ratio float64, minSize int,
+) ShardedAVLTreeOption[TKey, TValue] {
+	return func(tree *ShardedAVLTree[TKey, TValue]) error {
+		if ratio != 0 && !(ratio > 1) {
+			return &ErrorInvalidRebalanceRatio{
+				ratio: ratio,
+			}
+		}
+
+		tree.rebalanceRatio = ratio
+		tree.rebalanceMinSize = minSize
+
+		return nil
+	}
+}
+
+type WatcherOption[
+	TKey any, TValue any,
+] func(watcher *Watcher[TKey, TValue]) error
+
+// WatcherOptionWithBuffer sets the number
+// of events the watcher channel holds.
+func WatcherOptionWithBuffer[
+	TKey any, TValue any,
+](
+	size int,
+) WatcherOption[TKey, TValue] {
+	return func(watcher *Watcher[TKey, TValue]) error {
+		if size < 0 {
+			return &ErrorNegativeCapacity{
+				capacity: size,
+			}
+		}
+
+		watcher.buffer = size
+
+		return nil
+	}
+}
+
+// WatcherOptionWithBackpressure sets what the
+// write does if the watcher channel is full.
+func WatcherOptionWithBackpressure[
+	TKey any, TValue any,
+](
+	policy Backpressure,
+) WatcherOption[TKey, TValue] {
+	return func(watcher *Watcher[TKey, TValue]) error {
+		switch policy {
+		case BackpressureBlock, BackpressureDropNewest, BackpressureDropOldest:
+
+		default:
+			return &ErrorUnknownBackpressure{
+				policy: policy,
+			}
+		}
+
+		watcher.backpressure = policy
+
+		return nil
+	}
+}
+
+type ExpiringAVLTreeOption[
+	TKey constraints.Ordered, TValue any,
+] func(tree *ExpiringAVLTree[TKey, TValue]) error
+
+// ExpiringAVLTreeOptionWithClock makes the tree
+// take the current time from the clock rather
+// than from time.Now. The clock must not be nil.
+func ExpiringAVLTreeOptionWithClock[
+	TKey constraints.Ordered, TValue any,
+](
+	now func() time.Time,
+) ExpiringAVLTreeOption[TKey, TValue] {
+	return func(tree *ExpiringAVLTree[TKey, TValue]) error {
+		if now == nil {
+			return &ErrorNilClock{}
+		}
+
+		tree.now = now
+
+		return nil
+	}
+}
+
+// ExpiringAVLTreeOptionOnExpire registers the
+// callback called after an expired entry is
+// removed. It must not write to the tree.
+func ExpiringAVLTreeOptionOnExpire[
+	TKey constraints.Ordered, TValue any,
+](
+	onExpire func(key TKey, value TValue),
+) ExpiringAVLTreeOption[TKey, TValue] {
+	return func(tree *ExpiringAVLTree[TKey, TValue]) error {
+		tree.onExpire = onExpire
+		return nil
+	}
+}
+
+type DurableAVLTreeOption[
+	TKey constraints.Ordered, TValue any,
+] func(tree *DurableAVLTree[TKey, TValue]) error
+
+// DurableAVLTreeOptionWithSync sets when the log is
+// flushed to the storage device. The interval is only
+// used by SyncInterval and must be positive for it.
+func DurableAVLTreeOptionWithSync[
+	TKey constraints.Ordered, TValue any,
+](
+	policy SyncPolicy, interval time.Duration,
+) DurableAVLTreeOption[TKey, TValue] {
+	return func(tree *DurableAVLTree[TKey, TValue]) error {
+		switch policy {
+		case SyncAlways, SyncNever:
+
+		case SyncInterval:
+			if interval <= 0 {
+				return &ErrorNonPositiveSyncInterval{
+					interval: interval,
+				}
+			}
+
+		default:
+			return &ErrorUnknownSyncPolicy{
+				policy: policy,
+			}
+		}
+
+		tree.syncPolicy = policy
+		tree.syncInterval = interval
+
+		return nil
+	}
+}
+
+// DurableAVLTreeOptionWithCompaction sets the log size
+// in bytes which makes the tree compact the log into
+// the snapshot. Zero disables the automatic compaction.
+func DurableAVLTreeOptionWithCompaction[
+	TKey constraints.Ordered, TValue any,
+](
+	maxLogSize int64,
+) DurableAVLTreeOption[TKey, TValue] {
+	return func(tree *DurableAVLTree[TKey, TValue]) error {
+		if maxLogSize < 0 {
+			return &ErrorNegativeCompactionSize{
+				size: maxLogSize,
+			}
+		}
+
+		tree.compactionSize = maxLogSize
+
+		return nil
+	}
+}
+
+// DurableAVLTreeOptionWithKeyCodec sets the functions
+// which encode the keys to the files and decode them
+// back instead of gob.
+func DurableAVLTreeOptionWithKeyCodec[
+	TKey constraints.Ordered, TValue any,
+](
+	encode func(key TKey) ([]byte, error),
+	decode func(data []byte) (TKey, error),
+) DurableAVLTreeOption[TKey, TValue] {
+	return func(tree *DurableAVLTree[TKey, TValue]) error {
+		tree.encodeKey = encode
+		tree.decodeKey = decode
+
+		return nil
+	}
+}
+
+// DurableAVLTreeOptionWithValueCodec sets the functions
+// which encode the values to the files and decode them
+// back instead of gob.
+func DurableAVLTreeOptionWithValueCodec[
+	TKey constraints.Ordered, TValue any,
+](
+	encode func(value TValue) ([]byte, error),
+	decode func(data []byte) (TValue, error),
+) DurableAVLTreeOption[TKey, TValue] {
+	return func(tree *DurableAVLTree[TKey, TValue]) error {
+		tree.encodeValue = encode
+		tree.decodeValue = decode
+
 		return nil
 	}
 }
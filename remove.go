@@ -0,0 +1,518 @@
+package avltree
+
+import "math/bits"
+
+// worthRebuilding tells if removing the number
+// of nodes one by one costs more than rebuilding
+// the whole tree. Unlinking a node costs about
+// 4 times more per tree level than rebuilding.
+func worthRebuilding(removed, size int) bool {
+	return 4*removed*bits.Len(uint(size)) >= size
+}
+
+// RemoveRange removes all the keys within [lo, hi], returns
+// the removed nodes to the pool and reports their number.
+// The tree is split by the bounds and the outer parts are
+// joined back, so only O(log n) nodes are restructured.
+func (t *AVLTree[TKey, TValue]) RemoveRange(lo, hi TKey) int {
+	if lo > hi {
+		return 0
+	}
+
+	t.collect()
+
+	left, rest := t.split(t.root, func(node *AVLNode[TKey, TValue]) bool {
+		return node.key < lo
+	})
+	removed, right := t.split(rest, func(node *AVLNode[TKey, TValue]) bool {
+		return !(node.key > hi)
+	})
+
+	t.root = t.merge(left, right)
+
+	if t.parentLinks && t.root != nil {
+		t.root.parent = nil
+	}
+
+	count := t.releaseAll(removed)
+	t.size -= count
+
+	return count
+}
+
+// RemoveIf removes all the nodes satisfying the predicate,
+// returns them to the pool and reports their number.
+// Depending on the number of nodes to remove, they are either
+// unlinked one by one or the tree is rebuilt without them.
+// The predicate must not modify the tree.
+func (t *AVLTree[TKey, TValue]) RemoveIf(pred func(node *AVLNode[TKey, TValue]) bool) int {
+	var stack [maxHeight]*AVLNode[TKey, TValue]
+	top := 0
+	node := t.root
+	kept := make([]*AVLNode[TKey, TValue], 0, t.size)
+	removed := []*AVLNode[TKey, TValue]{}
+
+	for node != nil || top > 0 {
+		for node != nil {
+			stack[top] = node
+			top++
+			node = node.left
+		}
+
+		top--
+		node = stack[top]
+
+		if pred(node) {
+			removed = append(removed, node)
+		} else {
+			kept = append(kept, node)
+		}
+
+		node = node.right
+	}
+
+	if len(removed) <= 0 {
+		return 0
+	}
+
+	// the nodes shared with the snapshots
+	// can't be relinked in place
+	if t.shared() || !worthRebuilding(len(removed), t.size) {
+		for _, node := range removed {
+			path, slot := t.find(node.key)
+			t.release(t.unlink(path, slot))
+		}
+
+		return len(removed)
+	}
+
+	t.root = t.build(kept, nil)
+	t.size = len(kept)
+
+	var zeroValTValue TValue
+
+	for _, node := range removed {
+		node.left = nil
+		node.right = nil
+		node.parent = nil
+
+		t.notify(EventDelete, node.key, node.Value, zeroValTValue)
+		t.release(node)
+	}
+
+	return len(removed)
+}
+
+// Splits the subtree into the nodes satisfying
+// the predicate and the rest. The predicate must
+// hold for a prefix of the nodes in key order
+func (t *AVLTree[TKey, TValue]) split(node *AVLNode[TKey, TValue], less func(node *AVLNode[TKey, TValue]) bool) (*AVLNode[TKey, TValue], *AVLNode[TKey, TValue]) {
+	if node == nil {
+		return nil, nil
+	}
+
+	node = t.own(&node)
+	left, right := node.left, node.right
+
+	if less(node) {
+		lt, ge := t.split(right, less)
+		return t.join(left, node, lt), ge
+	}
+
+	lt, ge := t.split(left, less)
+
+	return lt, t.join(ge, node, right)
+}
+
+// Joins two subtrees with all the keys of the left one
+// less than the key of the middle node and all the keys
+// of the right one greater than it
+func (t *AVLTree[TKey, TValue]) join(left, middle, right *AVLNode[TKey, TValue]) *AVLNode[TKey, TValue] {
+	leftHeight, rightHeight := left.getHeight(), right.getHeight()
+
+	if leftHeight > rightHeight+1 {
+		left = t.own(&left)
+		left.right = t.join(left.right, middle, right)
+
+		if t.parentLinks {
+			left.right.parent = left
+		}
+
+		return t.rebalance(left)
+	}
+
+	if rightHeight > leftHeight+1 {
+		right = t.own(&right)
+		right.left = t.join(left, middle, right.left)
+
+		if t.parentLinks {
+			right.left.parent = right
+		}
+
+		return t.rebalance(right)
+	}
+
+	middle.left = left
+	middle.right = right
+
+	if t.parentLinks {
+		if left != nil {
+			left.parent = middle
+		}
+
+		if right != nil {
+			right.parent = middle
+		}
+	}
+
+	middle.recalculateHeight()
+	middle.recalculateHash()
+
+	return middle
+}
+
+// Joins two subtrees with all the keys of the left
+// one less than all the keys of the right one
+func (t *AVLTree[TKey, TValue]) merge(left, right *AVLNode[TKey, TValue]) *AVLNode[TKey, TValue] {
+	if right == nil {
+		return left
+	}
+
+	smallest, rest := t.splitSmallest(right)
+
+	return t.join(left, smallest, rest)
+}
+
+// Detaches the smallest node of the subtree
+// and returns it along with the rest of the subtree
+func (t *AVLTree[TKey, TValue]) splitSmallest(node *AVLNode[TKey, TValue]) (*AVLNode[TKey, TValue], *AVLNode[TKey, TValue]) {
+	node = t.own(&node)
+
+	if node.left == nil {
+		return node, node.right
+	}
+
+	smallest, left := t.splitSmallest(node.left)
+	node.left = left
+
+	if t.parentLinks && left != nil {
+		left.parent = node
+	}
+
+	return smallest, t.rebalance(node)
+}
+
+// Returns all the nodes of the subtree to the pool
+// and reports their number. The watchers are
+// notified of the removal of each node.
+// The nodes shared with the snapshots are
+// only returned once they're released
+func (t *AVLTree[TKey, TValue]) releaseAll(node *AVLNode[TKey, TValue]) int {
+	if node == nil {
+		return 0
+	}
+
+	shared := t.shared()
+
+	if shared {
+		defer unrefNodes(node, t.release)
+	}
+
+	var zeroValTValue TValue
+
+	// each level holds at most one
+	// pending right sibling on the stack
+	var stack [maxHeight + 1]*AVLNode[TKey, TValue]
+	stack[0] = node
+	top := 1
+	count := 0
+
+	for top > 0 {
+		top--
+		node := stack[top]
+
+		if node.right != nil {
+			stack[top] = node.right
+			top++
+		}
+
+		if node.left != nil {
+			stack[top] = node.left
+			top++
+		}
+
+		t.notify(EventDelete, node.key, node.Value, zeroValTValue)
+
+		if !shared {
+			t.release(node)
+		}
+
+		count++
+	}
+
+	return count
+}
+
+// Builds a perfectly balanced
+// tree from the sorted nodes
+func (t *AVLTree[TKey, TValue]) build(nodes []*AVLNode[TKey, TValue], parent *AVLNode[TKey, TValue]) *AVLNode[TKey, TValue] {
+	if len(nodes) <= 0 {
+		return nil
+	}
+
+	mid := len(nodes) / 2
+	node := nodes[mid]
+	node.left = t.build(nodes[:mid], node)
+	node.right = t.build(nodes[mid+1:], node)
+	node.height = bits.Len(uint(len(nodes)))
+	node.recalculateHash()
+
+	if t.parentLinks {
+		node.parent = parent
+	}
+
+	return node
+}
+
+// RemoveRange removes all the keys within [lo, hi], returns
+// the removed nodes to the pool and reports their number.
+// The tree is split by the bounds and the outer parts are
+// joined back, so only O(log n) nodes are restructured.
+func (t *UnrestrictedAVLTree[TKey, TValue]) RemoveRange(lo, hi TKey) int {
+	if lo.Greater(hi) {
+		return 0
+	}
+
+	left, rest := t.split(t.root, func(node *UnrestrictedAVLNode[TKey, TValue]) bool {
+		return node.key.Less(lo)
+	})
+	removed, right := t.split(rest, func(node *UnrestrictedAVLNode[TKey, TValue]) bool {
+		return !(node.key.Greater(hi))
+	})
+
+	t.root = t.merge(left, right)
+
+	if t.parentLinks && t.root != nil {
+		t.root.parent = nil
+	}
+
+	count := t.releaseAll(removed)
+	t.size -= count
+
+	return count
+}
+
+// RemoveIf removes all the nodes satisfying the predicate,
+// returns them to the pool and reports their number.
+// Depending on the number of nodes to remove, they are either
+// unlinked one by one or the tree is rebuilt without them.
+// The predicate must not modify the tree.
+func (t *UnrestrictedAVLTree[TKey, TValue]) RemoveIf(pred func(node *UnrestrictedAVLNode[TKey, TValue]) bool) int {
+	var stack [maxHeight]*UnrestrictedAVLNode[TKey, TValue]
+	top := 0
+	node := t.root
+	kept := make([]*UnrestrictedAVLNode[TKey, TValue], 0, t.size)
+	removed := []*UnrestrictedAVLNode[TKey, TValue]{}
+
+	for node != nil || top > 0 {
+		for node != nil {
+			stack[top] = node
+			top++
+			node = node.left
+		}
+
+		top--
+		node = stack[top]
+
+		if pred(node) {
+			removed = append(removed, node)
+		} else {
+			kept = append(kept, node)
+		}
+
+		node = node.right
+	}
+
+	if len(removed) <= 0 {
+		return 0
+	}
+
+	if !worthRebuilding(len(removed), t.size) {
+		for _, node := range removed {
+			path, slot := t.find(node.key)
+			t.release(t.unlink(path, slot))
+		}
+
+		return len(removed)
+	}
+
+	t.root = t.build(kept, nil)
+	t.size = len(kept)
+
+	var zeroValTValue TValue
+
+	for _, node := range removed {
+		node.left = nil
+		node.right = nil
+		node.parent = nil
+
+		t.notify(EventDelete, node.key, node.Value, zeroValTValue)
+		t.release(node)
+	}
+
+	return len(removed)
+}
+
+// Splits the subtree into the nodes satisfying
+// the predicate and the rest. The predicate must
+// hold for a prefix of the nodes in key order
+func (t *UnrestrictedAVLTree[TKey, TValue]) split(node *UnrestrictedAVLNode[TKey, TValue], less func(node *UnrestrictedAVLNode[TKey, TValue]) bool) (*UnrestrictedAVLNode[TKey, TValue], *UnrestrictedAVLNode[TKey, TValue]) {
+	if node == nil {
+		return nil, nil
+	}
+
+	left, right := node.left, node.right
+
+	if less(node) {
+		lt, ge := t.split(right, less)
+		return t.join(left, node, lt), ge
+	}
+
+	lt, ge := t.split(left, less)
+
+	return lt, t.join(ge, node, right)
+}
+
+// Joins two subtrees with all the keys of the left one
+// less than the key of the middle node and all the keys
+// of the right one greater than it
+func (t *UnrestrictedAVLTree[TKey, TValue]) join(left, middle, right *UnrestrictedAVLNode[TKey, TValue]) *UnrestrictedAVLNode[TKey, TValue] {
+	leftHeight, rightHeight := left.getHeight(), right.getHeight()
+
+	if leftHeight > rightHeight+1 {
+		left.right = t.join(left.right, middle, right)
+
+		if t.parentLinks {
+			left.right.parent = left
+		}
+
+		return t.rebalance(left)
+	}
+
+	if rightHeight > leftHeight+1 {
+		right.left = t.join(left, middle, right.left)
+
+		if t.parentLinks {
+			right.left.parent = right
+		}
+
+		return t.rebalance(right)
+	}
+
+	middle.left = left
+	middle.right = right
+
+	if t.parentLinks {
+		if left != nil {
+			left.parent = middle
+		}
+
+		if right != nil {
+			right.parent = middle
+		}
+	}
+
+	middle.recalculateHeight()
+	middle.recalculateHash()
+
+	return middle
+}
+
+// Joins two subtrees with all the keys of the left
+// one less than all the keys of the right one
+func (t *UnrestrictedAVLTree[TKey, TValue]) merge(left, right *UnrestrictedAVLNode[TKey, TValue]) *UnrestrictedAVLNode[TKey, TValue] {
+	if right == nil {
+		return left
+	}
+
+	smallest, rest := t.splitSmallest(right)
+
+	return t.join(left, smallest, rest)
+}
+
+// Detaches the smallest node of the subtree
+// and returns it along with the rest of the subtree
+func (t *UnrestrictedAVLTree[TKey, TValue]) splitSmallest(node *UnrestrictedAVLNode[TKey, TValue]) (*UnrestrictedAVLNode[TKey, TValue], *UnrestrictedAVLNode[TKey, TValue]) {
+	if node.left == nil {
+		return node, node.right
+	}
+
+	smallest, left := t.splitSmallest(node.left)
+	node.left = left
+
+	if t.parentLinks && left != nil {
+		left.parent = node
+	}
+
+	return smallest, t.rebalance(node)
+}
+
+// Returns all the nodes of the subtree to the pool
+// and reports their number. The watchers are
+// notified of the removal of each node
+func (t *UnrestrictedAVLTree[TKey, TValue]) releaseAll(node *UnrestrictedAVLNode[TKey, TValue]) int {
+	if node == nil {
+		return 0
+	}
+
+	var zeroValTValue TValue
+
+	// each level holds at most one
+	// pending right sibling on the stack
+	var stack [maxHeight + 1]*UnrestrictedAVLNode[TKey, TValue]
+	stack[0] = node
+	top := 1
+	count := 0
+
+	for top > 0 {
+		top--
+		node := stack[top]
+
+		if node.right != nil {
+			stack[top] = node.right
+			top++
+		}
+
+		if node.left != nil {
+			stack[top] = node.left
+			top++
+		}
+
+		t.notify(EventDelete, node.key, node.Value, zeroValTValue)
+		t.release(node)
+		count++
+	}
+
+	return count
+}
+
+// Builds a perfectly balanced
+// tree from the sorted nodes
+func (t *UnrestrictedAVLTree[TKey, TValue]) build(nodes []*UnrestrictedAVLNode[TKey, TValue], parent *UnrestrictedAVLNode[TKey, TValue]) *UnrestrictedAVLNode[TKey, TValue] {
+	if len(nodes) <= 0 {
+		return nil
+	}
+
+	mid := len(nodes) / 2
+	node := nodes[mid]
+	node.left = t.build(nodes[:mid], node)
+	node.right = t.build(nodes[mid+1:], node)
+	node.height = bits.Len(uint(len(nodes)))
+	node.recalculateHash()
+
+	if t.parentLinks {
+		node.parent = parent
+	}
+
+	return node
+}
@@ -0,0 +1,113 @@
+package avltree_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zergon321/go-avltree"
+	"github.com/zergon321/mempool"
+)
+
+func TestRemoveRange(t *testing.T) {
+	for _, width := range []int{3, 100, 900} {
+		tree, err := avltree.NewAVLTree(
+			avltree.AVLTreeOptionWithParentLinks[int, int]())
+		assert.Nil(t, err)
+
+		for i := 0; i < 1000; i++ {
+			tree.Add(i, i)
+		}
+
+		lo := rand.Intn(1000 - width)
+		hi := lo + width - 1
+
+		assert.Equal(t, width, tree.RemoveRange(lo, hi))
+		assert.Equal(t, 1000-width, tree.Len())
+		assert.Nil(t, tree.Validate())
+
+		for i := 0; i < 1000; i++ {
+			node := tree.Search(i)
+
+			if i >= lo && i <= hi {
+				assert.Nil(t, node)
+			} else {
+				assert.NotNil(t, node)
+			}
+		}
+
+		assert.Equal(t, 0, tree.RemoveRange(lo, hi))
+	}
+}
+
+func TestRemoveIf(t *testing.T) {
+	created := 0
+	pool, err := mempool.NewPool(func() *avltree.UnrestrictedAVLNode[IntKey, int] {
+		created++
+		return &avltree.UnrestrictedAVLNode[IntKey, int]{}
+	})
+	assert.Nil(t, err)
+
+	tree, err := avltree.NewUnrestrictedAVLTree(
+		avltree.UnrestrictedAVLTreeOptionWithMemoryPool(pool))
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		tree.Add(IntKey(i), i)
+	}
+
+	for _, mod := range []int{500, 2} {
+		removed := tree.RemoveIf(func(node *avltree.UnrestrictedAVLNode[IntKey, int]) bool {
+			return node.Value%mod == 0
+		})
+		assert.Greater(t, removed, 0)
+		assert.Nil(t, tree.Validate())
+	}
+
+	assert.Equal(t, 500, tree.Len())
+	assert.Equal(t, 1, tree.RemoveRange(IntKey(100), IntKey(102)))
+
+	err = tree.VisitInOrder(func(node *avltree.UnrestrictedAVLNode[IntKey, int]) error {
+		assert.Equal(t, 1, node.Value%2)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	// the removed nodes are reused
+	for i := 0; i < 501; i += 2 {
+		tree.Add(IntKey(i), i)
+	}
+
+	assert.Equal(t, 1000, created)
+}
+
+func BenchmarkAVLRemoveRange(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		tree := &avltree.AVLTree[int, int]{}
+
+		for j := 0; j < 1<<14; j++ {
+			tree.Add(j, j)
+		}
+
+		b.StartTimer()
+		tree.RemoveRange(1<<12, 1<<13)
+	}
+}
+
+func BenchmarkAVLRemoveRangeOneByOne(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		tree := &avltree.AVLTree[int, int]{}
+
+		for j := 0; j < 1<<14; j++ {
+			tree.Add(j, j)
+		}
+
+		b.StartTimer()
+
+		for j := 1 << 12; j <= 1<<13; j++ {
+			tree.Remove(j)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package avltree
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/exp/constraints"
+)
+
+const (
+	// defaultRebalanceRatio is how many times a shard
+	// may exceed the average shard size before its
+	// boundaries are moved.
+	defaultRebalanceRatio = 2.0
+	// defaultRebalanceMinSize is the size a shard
+	// has to reach before its boundaries are moved.
+	defaultRebalanceMinSize = 1024
+)
+
+// errStopVisit stops the in-order
+// traversal of a tree early.
+var errStopVisit = errors.New("stop visit")
+
+// ShardedAVLTree is an ordered map split into AVL tree
+// shards by key ranges. Each shard has its own lock, so
+// writes to different shards don't contend. Shard i holds
+// the keys in [splits[i-1], splits[i]). If a shard grows
+// too large compared to the average, a part of its keys
+// is moved to the smaller neighbour and the boundary
+// between them is shifted.
+type ShardedAVLTree[TKey constraints.Ordered, TValue any] struct {
+	// layout guards the split points and the
+	// shard boundaries. It's taken exclusively
+	// only to move the boundaries.
+	layout sync.RWMutex
+	splits []TKey
+	shards []*avlShard[TKey, TValue]
+	size   atomic.Int64
+
+	// rebalanceRatio is zero if the
+	// boundaries never move.
+	rebalanceRatio   float64
+	rebalanceMinSize int
+}
+
+type avlShard[TKey constraints.Ordered, TValue any] struct {
+	mu   sync.RWMutex
+	tree AVLTree[TKey, TValue]
+}
+
+func (t *ShardedAVLTree[TKey, TValue]) Add(key TKey, value TValue) {
+	t.layout.RLock()
+	i := t.shardIndex(key)
+	shard := t.shards[i]
+
+	shard.mu.Lock()
+	size := shard.tree.Len()
+	shard.tree.Add(key, value)
+	added := shard.tree.Len() > size
+	size = shard.tree.Len()
+	shard.mu.Unlock()
+
+	t.layout.RUnlock()
+
+	if !added {
+		return
+	}
+
+	total := t.size.Add(1)
+
+	if t.overloaded(size, int(total)) {
+		t.rebalance(key)
+	}
+}
+
+// Remove removes the key from the tree. Reports
+// whether there has been such key in the tree.
+func (t *ShardedAVLTree[TKey, TValue]) Remove(key TKey) bool {
+	t.layout.RLock()
+	defer t.layout.RUnlock()
+
+	shard := t.shards[t.shardIndex(key)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	size := shard.tree.Len()
+	shard.tree.Remove(key)
+
+	if shard.tree.Len() == size {
+		return false
+	}
+
+	t.size.Add(-1)
+
+	return true
+}
+
+// Search returns the value stored by the key
+// and whether there's such key in the tree.
+func (t *ShardedAVLTree[TKey, TValue]) Search(key TKey) (TValue, bool) {
+	var zeroValTValue TValue
+
+	t.layout.RLock()
+	defer t.layout.RUnlock()
+
+	shard := t.shards[t.shardIndex(key)]
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	node := shard.tree.Search(key)
+
+	if node == nil {
+		return zeroValTValue, false
+	}
+
+	return node.Value, true
+}
+
+// VisitInOrder visits the keys of all the shards in
+// the ascending order. Each shard is read-locked while
+// it's visited, so the traversal isn't an atomic
+// snapshot of the whole tree. The visit function
+// must not write to the tree.
+func (t *ShardedAVLTree[TKey, TValue]) VisitInOrder(visit func(key TKey, value TValue) error) error {
+	t.layout.RLock()
+	defer t.layout.RUnlock()
+
+	for _, shard := range t.shards {
+		shard.mu.RLock()
+		err := shard.tree.VisitInOrder(func(node *AVLNode[TKey, TValue]) error {
+			return visit(node.key, node.Value)
+		})
+		shard.mu.RUnlock()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Len returns the number of keys in the tree.
+func (t *ShardedAVLTree[TKey, TValue]) Len() int {
+	return int(t.size.Load())
+}
+
+// SplitPoints returns the current
+// boundaries between the shards.
+func (t *ShardedAVLTree[TKey, TValue]) SplitPoints() []TKey {
+	t.layout.RLock()
+	defer t.layout.RUnlock()
+
+	return append([]TKey{}, t.splits...)
+}
+
+// Returns the index of the shard holding the key
+func (t *ShardedAVLTree[TKey, TValue]) shardIndex(key TKey) int {
+	return sort.Search(len(t.splits), func(i int) bool {
+		return key < t.splits[i]
+	})
+}
+
+// Tells if the shard of the size has to be
+// rebalanced given the total number of keys
+func (t *ShardedAVLTree[TKey, TValue]) overloaded(size, total int) bool {
+	if t.rebalanceRatio <= 0 || len(t.shards) < 2 || size < t.rebalanceMinSize {
+		return false
+	}
+
+	return float64(size) > t.rebalanceRatio*float64(total)/float64(len(t.shards))
+}
+
+// Moves a part of the keys of the shard holding
+// the key to its smaller neighbour and shifts
+// the boundary between them
+func (t *ShardedAVLTree[TKey, TValue]) rebalance(key TKey) {
+	t.layout.Lock()
+	defer t.layout.Unlock()
+
+	// no other operations run while the layout
+	// is locked, so the shards aren't locked
+	i := t.shardIndex(key)
+	size := t.shards[i].tree.Len()
+
+	// the shard could have been
+	// rebalanced by another writer
+	if !t.overloaded(size, t.Len()) {
+		return
+	}
+
+	neighbour := i - 1
+
+	if i == 0 || i+1 < len(t.shards) && t.shards[i+1].tree.Len() < t.shards[i-1].tree.Len() {
+		neighbour = i + 1
+	}
+
+	count := (size - t.shards[neighbour].tree.Len()) / 2
+
+	if count <= 0 {
+		return
+	}
+
+	if neighbour < i {
+		t.splits[i-1] = t.moveFirst(t.shards[i], t.shards[neighbour], count)
+	} else {
+		t.splits[i] = t.moveLast(t.shards[i], t.shards[neighbour], count)
+	}
+}
+
+// Moves the count smallest keys from the shard to the
+// destination and returns the new smallest key of the shard
+func (t *ShardedAVLTree[TKey, TValue]) moveFirst(shard, dst *avlShard[TKey, TValue], count int) TKey {
+	var (
+		first, last, boundary TKey
+		moved                 int
+	)
+
+	shard.tree.VisitInOrder(func(node *AVLNode[TKey, TValue]) error {
+		if moved >= count {
+			boundary = node.key
+			return errStopVisit
+		}
+
+		if moved == 0 {
+			first = node.key
+		}
+
+		last = node.key
+		moved++
+		dst.tree.Add(node.key, node.Value)
+
+		return nil
+	})
+
+	shard.tree.RemoveRange(first, last)
+
+	return boundary
+}
+
+// Moves the count largest keys from the shard to the
+// destination and returns the smallest of the moved keys
+func (t *ShardedAVLTree[TKey, TValue]) moveLast(shard, dst *avlShard[TKey, TValue], count int) TKey {
+	var first, last TKey
+	skip := shard.tree.Len() - count
+	visited := 0
+
+	shard.tree.VisitInOrder(func(node *AVLNode[TKey, TValue]) error {
+		visited++
+
+		if visited <= skip {
+			return nil
+		}
+
+		if visited == skip+1 {
+			first = node.key
+		}
+
+		last = node.key
+		dst.tree.Add(node.key, node.Value)
+
+		return nil
+	})
+
+	shard.tree.RemoveRange(first, last)
+
+	return first
+}
+
+// NewShardedAVLTree creates a new sharded AVL tree with
+// len(splits)+1 shards split by the ascending split points.
+func NewShardedAVLTree[
+	TKey constraints.Ordered, TValue any,
+](
+	splits []TKey,
+	options ...ShardedAVLTreeOption[TKey, TValue],
+) (
+	*ShardedAVLTree[TKey, TValue], error,
+) {
+	for i := 1; i < len(splits); i++ {
+		if splits[i] <= splits[i-1] {
+			return nil, &ErrorInvalidSplitPoints{
+				index: i,
+			}
+		}
+	}
+
+	tree := &ShardedAVLTree[TKey, TValue]{
+		splits:           append([]TKey{}, splits...),
+		shards:           make([]*avlShard[TKey, TValue], len(splits)+1),
+		rebalanceRatio:   defaultRebalanceRatio,
+		rebalanceMinSize: defaultRebalanceMinSize,
+	}
+
+	for i := range tree.shards {
+		tree.shards[i] = &avlShard[TKey, TValue]{}
+	}
+
+	for i := 0; i < len(options); i++ {
+		option := options[i]
+		err := option(tree)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tree, nil
+}
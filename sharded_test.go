@@ -0,0 +1,168 @@
+package avltree_test
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zergon321/go-avltree"
+)
+
+func TestShardedAVLTree(t *testing.T) {
+	tree, err := avltree.NewShardedAVLTree[int, int]([]int{100, 200, 300})
+	assert.Nil(t, err)
+
+	ref := map[int]int{}
+
+	for i := 0; i < 3000; i++ {
+		key := rand.Intn(400)
+
+		if rand.Intn(3) == 0 {
+			_, existed := ref[key]
+			assert.Equal(t, existed, tree.Remove(key))
+			delete(ref, key)
+		} else {
+			tree.Add(key, i)
+			ref[key] = i
+		}
+	}
+
+	assert.Nil(t, tree.Validate())
+	assert.Equal(t, len(ref), tree.Len())
+
+	for key := 0; key < 400; key++ {
+		value, ok := tree.Search(key)
+		refValue, refOk := ref[key]
+
+		assert.Equal(t, refOk, ok)
+		assert.Equal(t, refValue, value)
+	}
+
+	prev := -1
+	count := 0
+	err = tree.VisitInOrder(func(key, value int) error {
+		assert.Greater(t, key, prev)
+		prev = key
+		count++
+
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, len(ref), count)
+
+	_, err = avltree.NewShardedAVLTree[int, int]([]int{1, 3, 3})
+	assert.NotNil(t, err)
+
+	_, err = avltree.NewShardedAVLTree[int, int](nil,
+		avltree.ShardedAVLTreeOptionWithRebalancing[int, int](0.5, 0))
+	assert.NotNil(t, err)
+}
+
+func TestShardedAVLTreeRebalancing(t *testing.T) {
+	tree, err := avltree.NewShardedAVLTree[int, int]([]int{1000, 2000, 3000},
+		avltree.ShardedAVLTreeOptionWithRebalancing[int, int](1.5, 16))
+	assert.Nil(t, err)
+
+	// all the keys go to the first shard
+	for i := 0; i < 1000; i++ {
+		tree.Add(i, i)
+	}
+
+	assert.Nil(t, tree.Validate())
+	assert.Equal(t, 1000, tree.Len())
+	assert.Less(t, tree.SplitPoints()[0], 1000)
+
+	for i := 0; i < 1000; i++ {
+		value, ok := tree.Search(i)
+		assert.True(t, ok)
+		assert.Equal(t, i, value)
+	}
+
+	fixed, err := avltree.NewShardedAVLTree[int, int]([]int{1000},
+		avltree.ShardedAVLTreeOptionWithRebalancing[int, int](0, 0))
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		fixed.Add(i, i)
+	}
+
+	assert.Equal(t, []int{1000}, fixed.SplitPoints())
+}
+
+func TestShardedAVLTreeStress(t *testing.T) {
+	const (
+		writers    = 8
+		operations = 5000
+		keys       = 512
+	)
+
+	tree, err := avltree.NewShardedAVLTree[int, int]([]int{1000, 2000, 3000},
+		avltree.ShardedAVLTreeOptionWithRebalancing[int, int](1.5, 32))
+	assert.Nil(t, err)
+
+	wg := &sync.WaitGroup{}
+
+	// each writer owns the keys equal to
+	// its index modulo the number of writers
+	finals := make([]map[int]int, writers)
+
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		finals[w] = map[int]int{}
+
+		go func(w int) {
+			defer wg.Done()
+
+			rnd := rand.New(rand.NewSource(int64(w)))
+			final := finals[w]
+
+			for i := 0; i < operations; i++ {
+				key := rnd.Intn(keys)*writers + w
+
+				switch rnd.Intn(3) {
+				case 0:
+					_, existed := final[key]
+
+					if tree.Remove(key) != existed {
+						t.Errorf("Remove(%d) reported a wrong result", key)
+						return
+					}
+
+					delete(final, key)
+
+				case 1:
+					tree.Add(key, i)
+					final[key] = i
+
+				case 2:
+					value, ok := tree.Search(key)
+					expected, existed := final[key]
+
+					if ok != existed || value != expected {
+						t.Errorf("Search(%d) = (%d, %t), expected (%d, %t)",
+							key, value, ok, expected, existed)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+	assert.Nil(t, tree.Validate())
+
+	count := 0
+
+	for _, final := range finals {
+		for key, expected := range final {
+			value, ok := tree.Search(key)
+			assert.True(t, ok)
+			assert.Equal(t, expected, value)
+		}
+
+		count += len(final)
+	}
+
+	assert.Equal(t, count, tree.Len())
+}
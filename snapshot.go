@@ -0,0 +1,251 @@
+package avltree
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/exp/constraints"
+)
+
+// AVLSnapshot is a read-only view of the tree at the
+// moment it's taken. The snapshot shares the nodes with
+// the tree, and the tree copies a shared node before
+// changing it, so taking a snapshot costs O(1) and the
+// writes to the tree only copy the paths they touch.
+// The snapshot can be read by another goroutine while
+// the tree is written. Release the snapshot once it's
+// not needed so the nodes only it references are
+// returned to the pool.
+type AVLSnapshot[TKey constraints.Ordered, TValue any] struct {
+	root     *AVLNode[TKey, TValue]
+	size     int
+	versions *avlVersions[TKey, TValue]
+	released atomic.Bool
+}
+
+// avlVersions is the state shared by
+// the tree and its snapshots.
+type avlVersions[TKey constraints.Ordered, TValue any] struct {
+	// live is the number of
+	// unreleased snapshots.
+	live atomic.Int64
+	// garbage holds the nodes freed
+	// by the snapshots until the tree
+	// returns them to the pool. The pool
+	// is only accessed by the writer.
+	mu      sync.Mutex
+	garbage []*AVLNode[TKey, TValue]
+	dirty   atomic.Bool
+}
+
+// Snapshot returns a read-only view of the current
+// version of the tree. The nodes stay with the snapshot,
+// and the first write to the tree after it copies the
+// nodes along its path. So the nodes and the value
+// pointers returned by the tree before the snapshot
+// is taken are invalidated by that write: they refer
+// to the frozen version rather than to the tree, and
+// writing through them changes the snapshot. Once the
+// snapshot is released, they may be reused for other
+// keys. Look the keys up again after the snapshot is
+// taken. Snapshots can't be taken of a tree with parent
+// links enabled.
+func (t *AVLTree[TKey, TValue]) Snapshot() (*AVLSnapshot[TKey, TValue], error) {
+	if t.parentLinks {
+		return nil, &ErrorSnapshotWithParentLinks{}
+	}
+
+	if t.versions == nil {
+		t.versions = &avlVersions[TKey, TValue]{}
+	}
+
+	if t.root != nil {
+		t.root.refs.Add(1)
+	}
+
+	t.versions.live.Add(1)
+
+	return &AVLSnapshot[TKey, TValue]{
+		root:     t.root,
+		size:     t.size,
+		versions: t.versions,
+	}, nil
+}
+
+// Search returns the value stored by the key
+// and whether there's such key in the snapshot.
+func (s *AVLSnapshot[TKey, TValue]) Search(key TKey) (TValue, bool) {
+	var zeroValTValue TValue
+	node := s.root.search(key)
+
+	if node == nil {
+		return zeroValTValue, false
+	}
+
+	return node.Value, true
+}
+
+// VisitInOrder visits the entries of the
+// snapshot in the ascending order of keys.
+func (s *AVLSnapshot[TKey, TValue]) VisitInOrder(visit func(key TKey, value TValue) error) error {
+	var stack [maxHeight]*AVLNode[TKey, TValue]
+	top := 0
+	node := s.root
+
+	for node != nil || top > 0 {
+		for node != nil {
+			stack[top] = node
+			top++
+			node = node.left
+		}
+
+		top--
+		node = stack[top]
+
+		err := visit(node.key, node.Value)
+
+		if err != nil {
+			return err
+		}
+
+		node = node.right
+	}
+
+	return nil
+}
+
+// Len returns the number of
+// entries in the snapshot.
+func (s *AVLSnapshot[TKey, TValue]) Len() int {
+	return s.size
+}
+
+// Release drops the snapshot. The nodes no longer
+// referenced by the tree or the other snapshots are
+// returned to the pool on the next write to the tree.
+// The snapshot must not be read after it's released.
+// Releasing it again does nothing.
+func (s *AVLSnapshot[TKey, TValue]) Release() {
+	if !s.released.CompareAndSwap(false, true) {
+		return
+	}
+
+	versions := s.versions
+	unrefNodes(s.root, func(node *AVLNode[TKey, TValue]) {
+		versions.mu.Lock()
+		versions.garbage = append(versions.garbage, node)
+		versions.dirty.Store(true)
+		versions.mu.Unlock()
+	})
+
+	s.root = nil
+	versions.live.Add(-1)
+}
+
+// Tells if there are snapshots
+// which may share the nodes
+func (t *AVLTree[TKey, TValue]) shared() bool {
+	return t.versions != nil && t.versions.live.Load() > 0
+}
+
+// Makes the node referenced by the slot owned only by the
+// tree so it can be modified. If the node is shared, it's
+// replaced by a copy which shares its children instead
+func (t *AVLTree[TKey, TValue]) own(slot **AVLNode[TKey, TValue]) *AVLNode[TKey, TValue] {
+	node := *slot
+
+	if node == nil || !t.shared() || node.refs.Load() <= 0 {
+		return node
+	}
+
+	var clone *AVLNode[TKey, TValue]
+
+	if t.pool != nil {
+		clone = t.pool.Get()
+		t.counters.taken()
+	} else {
+		clone = &AVLNode[TKey, TValue]{}
+	}
+
+	clone.key = node.key
+	clone.Value = node.Value
+	clone.height = node.height
+	clone.left = node.left
+	clone.right = node.right
+
+	if node.hash != nil {
+		hash := *node.hash
+		clone.hash = &hash
+	}
+
+	if clone.left != nil {
+		clone.left.refs.Add(1)
+	}
+
+	if clone.right != nil {
+		clone.right.refs.Add(1)
+	}
+
+	*slot = clone
+	unrefNodes(node, t.release)
+
+	return clone
+}
+
+// Returns the nodes freed by the
+// released snapshots to the pool
+func (t *AVLTree[TKey, TValue]) collect() {
+	if t.versions == nil || !t.versions.dirty.Load() {
+		return
+	}
+
+	versions := t.versions
+	versions.mu.Lock()
+	garbage := versions.garbage
+	versions.garbage = nil
+	versions.dirty.Store(false)
+	versions.mu.Unlock()
+
+	for _, node := range garbage {
+		t.release(node)
+	}
+}
+
+// Drops a reference to the subtree. The nodes
+// left without references are passed to free
+// and drop the references to their children
+func unrefNodes[TKey constraints.Ordered, TValue any](
+	node *AVLNode[TKey, TValue],
+	free func(node *AVLNode[TKey, TValue]),
+) {
+	if node == nil {
+		return
+	}
+
+	// each level holds at most one
+	// pending right sibling on the stack
+	var stack [maxHeight + 1]*AVLNode[TKey, TValue]
+	stack[0] = node
+	top := 1
+
+	for top > 0 {
+		top--
+		node := stack[top]
+
+		if node.refs.Add(-1) >= 0 {
+			continue
+		}
+
+		if node.right != nil {
+			stack[top] = node.right
+			top++
+		}
+
+		if node.left != nil {
+			stack[top] = node.left
+			top++
+		}
+
+		free(node)
+	}
+}
@@ -0,0 +1,275 @@
+package avltree_test
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zergon321/go-avltree"
+)
+
+// countingAllocator counts the nodes
+// taken from it and put back to it.
+type countingAllocator struct {
+	gets, puts int
+}
+
+func (alloc *countingAllocator) Get() *avltree.AVLNode[int, int] {
+	alloc.gets++
+	return &avltree.AVLNode[int, int]{}
+}
+
+func (alloc *countingAllocator) Put(node *avltree.AVLNode[int, int]) error {
+	alloc.puts++
+	return node.Erase()
+}
+
+func snapshotEntries(snapshot *avltree.AVLSnapshot[int, int]) map[int]int {
+	entries := map[int]int{}
+	snapshot.VisitInOrder(func(key, value int) error {
+		entries[key] = value
+		return nil
+	})
+
+	return entries
+}
+
+func TestSnapshot(t *testing.T) {
+	tree := newHashedTree(t)
+	ref := map[int]int{}
+
+	for i := 0; i < 200; i++ {
+		tree.Add(i, i)
+		ref[i] = i
+	}
+
+	type version struct {
+		snapshot *avltree.AVLSnapshot[int, int]
+		entries  map[int]int
+	}
+
+	versions := []version{}
+
+	for i := 0; i < 3000; i++ {
+		if i%300 == 0 {
+			snapshot, err := tree.Snapshot()
+			assert.Nil(t, err)
+
+			entries := map[int]int{}
+
+			for key, value := range ref {
+				entries[key] = value
+			}
+
+			versions = append(versions, version{snapshot, entries})
+		}
+
+		key := rand.Intn(300)
+
+		switch rand.Intn(6) {
+		case 0:
+			tree.Remove(key)
+			delete(ref, key)
+
+		case 1:
+			removed := tree.RemoveRange(key, key+5)
+
+			for k := key; k <= key+5; k++ {
+				if _, ok := ref[k]; ok {
+					removed--
+					delete(ref, k)
+				}
+			}
+
+			assert.Zero(t, removed)
+
+		case 2:
+			if tree.Update(key, key+300, i) {
+				delete(ref, key)
+				ref[key+300] = i
+			}
+
+		case 3:
+			tree.RemoveIf(func(node *avltree.AVLNode[int, int]) bool {
+				return node.Key()%97 == key%97
+			})
+
+			for k := range ref {
+				if k%97 == key%97 {
+					delete(ref, k)
+				}
+			}
+
+		default:
+			tree.Add(key, i)
+			ref[key] = i
+		}
+
+		if err := tree.Validate(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+
+		// release some of the snapshots
+		// while the rest are alive
+		if i%700 == 0 && len(versions) > 0 {
+			versions[0].snapshot.Release()
+			versions = versions[1:]
+		}
+	}
+
+	assert.Equal(t, len(ref), tree.Len())
+
+	for key, value := range ref {
+		node := tree.Search(key)
+		assert.NotNil(t, node)
+		assert.Equal(t, value, node.Value)
+	}
+
+	for _, version := range versions {
+		assert.Equal(t, len(version.entries), version.snapshot.Len())
+		assert.Equal(t, version.entries, snapshotEntries(version.snapshot))
+
+		for key, value := range version.entries {
+			snapshotValue, ok := version.snapshot.Search(key)
+			assert.True(t, ok)
+			assert.Equal(t, value, snapshotValue)
+		}
+
+		version.snapshot.Release()
+	}
+
+	assert.Nil(t, tree.Validate())
+}
+
+func TestSnapshotReturnsNodes(t *testing.T) {
+	alloc := &countingAllocator{}
+	tree, err := avltree.NewAVLTree(avltree.AVLTreeOptionWithAllocator[int, int](alloc))
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		tree.Add(i, i)
+	}
+
+	snapshot, err := tree.Snapshot()
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i += 2 {
+		tree.Remove(i)
+	}
+
+	tree.Clear()
+
+	// only the copies made by the writes are
+	// returned, the original nodes are still
+	// referenced by the snapshot
+	assert.Equal(t, alloc.gets-100, alloc.puts)
+	assert.Equal(t, 100, snapshot.Len())
+	assert.Equal(t, 100, len(snapshotEntries(snapshot)))
+
+	snapshot.Release()
+	snapshot.Release()
+
+	// the nodes are returned on the next write
+	tree.Add(0, 0)
+	assert.Equal(t, alloc.gets-1, alloc.puts)
+}
+
+func TestSnapshotInvalidatesHandles(t *testing.T) {
+	tree, err := avltree.NewAVLTree[int, int]()
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		tree.Add(i, i)
+	}
+
+	node := tree.Search(3)
+	value, _ := tree.GetOrInsert(3, func() int { return 0 })
+
+	snapshot, err := tree.Snapshot()
+	assert.Nil(t, err)
+
+	// the write copies the path to the key, so
+	// the old handles now refer to the snapshot
+	tree.Add(3, 30)
+	assert.NotSame(t, node, tree.Search(3))
+	assert.Equal(t, 3, node.Value)
+
+	*value = 300
+	frozen, _ := snapshot.Search(3)
+	assert.Equal(t, 300, frozen)
+	assert.Equal(t, 30, tree.Search(3).Value)
+
+	// the handles taken after the write
+	// refer to the tree again
+	value, _ = tree.GetOrInsert(3, func() int { return 0 })
+	*value = 31
+	assert.Equal(t, 31, tree.Search(3).Value)
+
+	snapshot.Release()
+}
+
+func TestSnapshotWithParentLinks(t *testing.T) {
+	tree, err := avltree.NewAVLTree(avltree.AVLTreeOptionWithParentLinks[int, int]())
+	assert.Nil(t, err)
+
+	_, err = tree.Snapshot()
+
+	var snapshotErr *avltree.ErrorSnapshotWithParentLinks
+	assert.ErrorAs(t, err, &snapshotErr)
+}
+
+func TestSnapshotConcurrentReaders(t *testing.T) {
+	tree, err := avltree.NewAVLTree[int, int]()
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		tree.Add(i, i)
+	}
+
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < 20; i++ {
+		snapshot, err := tree.Snapshot()
+		assert.Nil(t, err)
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer snapshot.Release()
+
+			count := 0
+			prev := -1
+
+			err := snapshot.VisitInOrder(func(key, value int) error {
+				if key <= prev || value != key {
+					return errors.New("inconsistent snapshot")
+				}
+
+				prev = key
+				count++
+
+				return nil
+			})
+
+			if err != nil || count != snapshot.Len() {
+				t.Error("inconsistent snapshot")
+			}
+		}()
+
+		for j := 0; j < 100; j++ {
+			key := rand.Intn(1000)
+
+			if rand.Intn(2) == 0 {
+				tree.Remove(key)
+			} else {
+				tree.Add(key, key)
+			}
+		}
+	}
+
+	wg.Wait()
+	assert.Nil(t, tree.Validate())
+}
@@ -0,0 +1,179 @@
+package avltree
+
+import "unsafe"
+
+// Stats describes the shape of the tree
+// and how it has been changed so far.
+type Stats struct {
+	// Nodes is the number of
+	// entries in the tree.
+	Nodes int
+	// Height counts the nodes on the
+	// longest path from the root.
+	Height int
+	// AverageDepth is the average number of
+	// edges between the root and a node.
+	AverageDepth float64
+	// BalanceFactors counts the nodes with the taller
+	// left subtree, the balanced nodes and the nodes
+	// with the taller right subtree.
+	BalanceFactors [3]int
+	// SingleRotations and DoubleRotations count
+	// the rebalancing rotations since the tree
+	// has been created.
+	SingleRotations uint64
+	DoubleRotations uint64
+	// PoolHits counts the nodes taken from the pool
+	// which the tree has returned there before.
+	// PoolMisses counts the rest of the nodes taken
+	// from the pool including the ones the pool has
+	// been filled with in advance.
+	PoolHits   uint64
+	PoolMisses uint64
+	// MemoryBytes is the estimated memory held by the
+	// nodes and the tree itself excluding the memory
+	// referenced by the keys and the values.
+	MemoryBytes int
+}
+
+// treeCounters accumulate the
+// statistics of the tree changes.
+type treeCounters struct {
+	singleRotations uint64
+	doubleRotations uint64
+	poolHits        uint64
+	poolMisses      uint64
+	// pooled is the number of nodes the tree
+	// has put to the pool and not taken back.
+	pooled int
+}
+
+// Counts the node taken from the pool
+func (c *treeCounters) taken() {
+	if c.pooled > 0 {
+		c.pooled--
+		c.poolHits++
+	} else {
+		c.poolMisses++
+	}
+}
+
+// Stats walks the tree and reports its statistics.
+func (t *AVLTree[TKey, TValue]) Stats() Stats {
+	var stack [maxHeight]struct {
+		node  *AVLNode[TKey, TValue]
+		depth int
+	}
+
+	stats := Stats{
+		Height:          t.root.getHeight(),
+		SingleRotations: t.counters.singleRotations,
+		DoubleRotations: t.counters.doubleRotations,
+		PoolHits:        t.counters.poolHits,
+		PoolMisses:      t.counters.poolMisses,
+	}
+	totalDepth := 0
+	top := 0
+
+	if t.root != nil {
+		stack[0].node = t.root
+		top++
+	}
+
+	for top > 0 {
+		top--
+		node, depth := stack[top].node, stack[top].depth
+
+		stats.Nodes++
+		totalDepth += depth
+		stats.BalanceFactors[balanceIndex(node.left.getHeight(), node.right.getHeight())]++
+
+		if node.hash != nil {
+			stats.MemoryBytes += int(unsafe.Sizeof(*node.hash))
+		}
+
+		for _, child := range [2]*AVLNode[TKey, TValue]{node.left, node.right} {
+			if child != nil {
+				stack[top].node = child
+				stack[top].depth = depth + 1
+				top++
+			}
+		}
+	}
+
+	if stats.Nodes > 0 {
+		stats.AverageDepth = float64(totalDepth) / float64(stats.Nodes)
+	}
+
+	stats.MemoryBytes += stats.Nodes*int(unsafe.Sizeof(AVLNode[TKey, TValue]{})) +
+		int(unsafe.Sizeof(*t)) + cap(t.path)*int(unsafe.Sizeof(&t.root))
+
+	return stats
+}
+
+// Stats walks the tree and reports its statistics.
+func (t *UnrestrictedAVLTree[TKey, TValue]) Stats() Stats {
+	var stack [maxHeight]struct {
+		node  *UnrestrictedAVLNode[TKey, TValue]
+		depth int
+	}
+
+	stats := Stats{
+		Height:          t.root.getHeight(),
+		SingleRotations: t.counters.singleRotations,
+		DoubleRotations: t.counters.doubleRotations,
+		PoolHits:        t.counters.poolHits,
+		PoolMisses:      t.counters.poolMisses,
+	}
+	totalDepth := 0
+	top := 0
+
+	if t.root != nil {
+		stack[0].node = t.root
+		top++
+	}
+
+	for top > 0 {
+		top--
+		node, depth := stack[top].node, stack[top].depth
+
+		stats.Nodes++
+		totalDepth += depth
+		stats.BalanceFactors[balanceIndex(node.left.getHeight(), node.right.getHeight())]++
+
+		if node.hash != nil {
+			stats.MemoryBytes += int(unsafe.Sizeof(*node.hash))
+		}
+
+		for _, child := range [2]*UnrestrictedAVLNode[TKey, TValue]{node.left, node.right} {
+			if child != nil {
+				stack[top].node = child
+				stack[top].depth = depth + 1
+				top++
+			}
+		}
+	}
+
+	if stats.Nodes > 0 {
+		stats.AverageDepth = float64(totalDepth) / float64(stats.Nodes)
+	}
+
+	stats.MemoryBytes += stats.Nodes*int(unsafe.Sizeof(UnrestrictedAVLNode[TKey, TValue]{})) +
+		int(unsafe.Sizeof(*t)) + cap(t.path)*int(unsafe.Sizeof(&t.root))
+
+	return stats
+}
+
+// Returns the index of the balance factor
+// of the subtree heights in the histogram
+func balanceIndex(left, right int) int {
+	if left > right {
+		return 0
+	}
+
+	if left < right {
+		return 2
+	}
+
+	return 1
+}
@@ -0,0 +1,70 @@
+package avltree_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zergon321/go-avltree"
+	"github.com/zergon321/mempool"
+)
+
+func TestStats(t *testing.T) {
+	tree, err := avltree.NewAVLTree[int, int]()
+	assert.Nil(t, err)
+	assert.Equal(t, avltree.Stats{
+		MemoryBytes: tree.Stats().MemoryBytes,
+	}, tree.Stats())
+
+	// the sequential inserts build
+	// a perfect tree of height 3
+	for i := 1; i <= 7; i++ {
+		tree.Add(i, i)
+	}
+
+	stats := tree.Stats()
+	assert.Equal(t, 7, stats.Nodes)
+	assert.Equal(t, 3, stats.Height)
+	assert.InDelta(t, 10.0/7.0, stats.AverageDepth, 1e-9)
+	assert.Equal(t, [3]int{0, 7, 0}, stats.BalanceFactors)
+	assert.Equal(t, uint64(4), stats.SingleRotations)
+	assert.Zero(t, stats.DoubleRotations)
+	assert.Greater(t, stats.MemoryBytes, 0)
+
+	tree.Clear()
+	tree.Add(3, 3)
+	tree.Add(1, 1)
+	tree.Add(2, 2)
+	tree.Add(4, 4)
+
+	stats = tree.Stats()
+	assert.Equal(t, uint64(1), stats.DoubleRotations)
+	assert.Equal(t, [3]int{0, 2, 2}, stats.BalanceFactors)
+}
+
+func TestStatsPool(t *testing.T) {
+	pool, err := mempool.NewPool(func() *avltree.UnrestrictedAVLNode[IntKey, int] {
+		return &avltree.UnrestrictedAVLNode[IntKey, int]{}
+	})
+	assert.Nil(t, err)
+
+	tree, err := avltree.NewUnrestrictedAVLTree(
+		avltree.UnrestrictedAVLTreeOptionWithMemoryPool(pool))
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		tree.Add(IntKey(i), i)
+	}
+
+	for i := 0; i < 6; i++ {
+		tree.Remove(IntKey(i))
+	}
+
+	for i := 0; i < 10; i++ {
+		tree.Add(IntKey(i+100), i)
+	}
+
+	stats := tree.Stats()
+	assert.Equal(t, 14, stats.Nodes)
+	assert.Equal(t, uint64(6), stats.PoolHits)
+	assert.Equal(t, uint64(14), stats.PoolMisses)
+}
@@ -0,0 +1,358 @@
+package avltree
+
+import (
+	"sort"
+
+	"golang.org/x/exp/constraints"
+)
+
+// Txn is a batch of writes to the tree which are either
+// all applied by Commit or all discarded by Rollback.
+// The transaction reads its own writes. The tree must
+// not be modified while the transaction is open.
+type Txn[TKey constraints.Ordered, TValue any] struct {
+	tree   *AVLTree[TKey, TValue]
+	writes map[TKey]*txnWrite[TKey, TValue]
+	closed bool
+}
+
+// txnWrite is the pending
+// change of a single key.
+type txnWrite[TKey constraints.Ordered, TValue any] struct {
+	value   TValue
+	deleted bool
+	// node is taken from the pool in advance
+	// if the key is absent from the tree so
+	// the commit never allocates.
+	node *AVLNode[TKey, TValue]
+}
+
+// Begin starts a new transaction on the tree.
+func (t *AVLTree[TKey, TValue]) Begin() *Txn[TKey, TValue] {
+	return &Txn[TKey, TValue]{
+		tree:   t,
+		writes: map[TKey]*txnWrite[TKey, TValue]{},
+	}
+}
+
+// Add sets the value by the key
+// within the transaction.
+func (txn *Txn[TKey, TValue]) Add(key TKey, value TValue) error {
+	if txn.closed {
+		return &ErrorTxnClosed{}
+	}
+
+	write, ok := txn.writes[key]
+
+	if !ok {
+		write = &txnWrite[TKey, TValue]{}
+		txn.writes[key] = write
+	}
+
+	write.value = value
+	write.deleted = false
+
+	if write.node == nil && txn.tree.root.search(key) == nil {
+		write.node = txn.tree.newNode(key, value)
+	}
+
+	return nil
+}
+
+// AddOrUpdate adds the value by the key if there's
+// no such key in the transaction view of the tree.
+// Otherwise it sets the value returned by upd
+// for the current value.
+func (txn *Txn[TKey, TValue]) AddOrUpdate(
+	key TKey, value TValue,
+	upd func(oldValue TValue) (TValue, error),
+) error {
+	if txn.closed {
+		return &ErrorTxnClosed{}
+	}
+
+	oldValue, ok := txn.Get(key)
+
+	if ok {
+		var err error
+		value, err = upd(oldValue)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return txn.Add(key, value)
+}
+
+// Remove deletes the key
+// within the transaction.
+func (txn *Txn[TKey, TValue]) Remove(key TKey) error {
+	if txn.closed {
+		return &ErrorTxnClosed{}
+	}
+
+	write, ok := txn.writes[key]
+
+	if !ok {
+		write = &txnWrite[TKey, TValue]{}
+		txn.writes[key] = write
+	}
+
+	var zeroValTValue TValue
+	write.value = zeroValTValue
+	write.deleted = true
+
+	if write.node != nil {
+		txn.tree.release(write.node)
+		write.node = nil
+	}
+
+	return nil
+}
+
+// Get returns the value by the key as
+// seen by the transaction and whether
+// the key exists.
+func (txn *Txn[TKey, TValue]) Get(key TKey) (TValue, bool) {
+	var zeroValTValue TValue
+
+	if write, ok := txn.writes[key]; ok {
+		if write.deleted {
+			return zeroValTValue, false
+		}
+
+		return write.value, true
+	}
+
+	node := txn.tree.root.search(key)
+
+	if node == nil {
+		return zeroValTValue, false
+	}
+
+	return node.Value, true
+}
+
+// Commit applies all the writes of the transaction
+// to the tree. The hooks and the watchers see the
+// changes once the whole batch is applied, then the
+// size bound evicts the entries exceeding it. If the
+// bound would evict an entry written by the transaction,
+// the transaction is rolled back and ErrorTxnEvictsOwnWrite
+// is returned. The victims chosen by the eviction function
+// can't be foreseen, so with one the commit fails if it
+// would make the tree exceed its bound at all.
+func (txn *Txn[TKey, TValue]) Commit() error {
+	if txn.closed {
+		return &ErrorTxnClosed{}
+	}
+
+	if txn.evictsOwnWrite() {
+		txn.Rollback()
+		return &ErrorTxnEvictsOwnWrite{}
+	}
+
+	txn.closed = true
+	tree := txn.tree
+	keys := txn.keys()
+	tree.deferring = true
+
+	for _, key := range keys {
+		write := txn.writes[key]
+
+		if write.deleted {
+			tree.remove(key)
+			continue
+		}
+
+		path, slot := tree.find(key)
+
+		if *slot != nil {
+			if write.node != nil {
+				tree.release(write.node)
+			}
+
+			tree.setValue(path, slot, write.value)
+			continue
+		}
+
+		write.node.Value = write.value
+		tree.hashEntry(write.node)
+		tree.attach(path, slot, write.node)
+	}
+
+	tree.deferring = false
+	events := tree.deferred
+	tree.deferred = nil
+
+	for _, event := range events {
+		tree.notify(event.Kind, event.Key, event.OldValue, event.NewValue)
+	}
+
+	if bound := tree.bound; bound != nil && len(keys) > 0 {
+		for bound.maxSize > 0 && tree.size > bound.maxSize {
+			tree.evict(keys[len(keys)-1])
+		}
+	}
+
+	txn.writes = nil
+
+	return nil
+}
+
+// Tells if the size bound would evict an entry
+// written by the transaction once it's committed
+func (txn *Txn[TKey, TValue]) evictsOwnWrite() bool {
+	tree := txn.tree
+	bound := tree.bound
+
+	if bound == nil || bound.maxSize <= 0 {
+		return false
+	}
+
+	size := tree.size
+	puts := 0
+
+	for key, write := range txn.writes {
+		exists := tree.root.search(key) != nil
+
+		switch {
+		case write.deleted:
+			if exists {
+				size--
+			}
+
+		case !exists:
+			size++
+			puts++
+
+		default:
+			puts++
+		}
+	}
+
+	excess := size - bound.maxSize
+
+	switch {
+	case excess <= 0:
+		return false
+
+	case bound.choose != nil:
+		return true
+
+	case bound.policy == EvictLRU:
+		// the written entries become the most recently
+		// used ones, so the others are evicted first
+		return puts > bound.maxSize
+	}
+
+	// the victims are the smallest or the largest keys
+	// of the committed tree, they're among the extreme
+	// keys of the tree and the written ones
+	largest := bound.policy == EvictLargest
+	candidates := tree.extremeKeys(excess+len(txn.writes), largest)
+
+	for key, write := range txn.writes {
+		if !write.deleted && tree.root.search(key) == nil {
+			candidates = append(candidates, key)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if largest {
+			return candidates[i] > candidates[j]
+		}
+
+		return candidates[i] < candidates[j]
+	})
+
+	for _, key := range candidates {
+		write, ok := txn.writes[key]
+
+		if ok && write.deleted {
+			continue
+		}
+
+		if ok {
+			return true
+		}
+
+		excess--
+
+		if excess <= 0 {
+			return false
+		}
+	}
+
+	return false
+}
+
+// Returns up to n smallest or
+// largest keys of the tree
+func (t *AVLTree[TKey, TValue]) extremeKeys(n int, largest bool) []TKey {
+	keys := make([]TKey, 0, n)
+
+	var walk func(node *AVLNode[TKey, TValue])
+	walk = func(node *AVLNode[TKey, TValue]) {
+		if node == nil || len(keys) >= n {
+			return
+		}
+
+		first, second := node.left, node.right
+
+		if largest {
+			first, second = second, first
+		}
+
+		walk(first)
+
+		if len(keys) < n {
+			keys = append(keys, node.key)
+		}
+
+		walk(second)
+	}
+
+	walk(t.root)
+
+	return keys
+}
+
+// Rollback discards all the writes of the
+// transaction returning the nodes taken
+// in advance to the pool.
+func (txn *Txn[TKey, TValue]) Rollback() error {
+	if txn.closed {
+		return &ErrorTxnClosed{}
+	}
+
+	txn.closed = true
+
+	for _, write := range txn.writes {
+		if write.node != nil {
+			txn.tree.release(write.node)
+		}
+	}
+
+	txn.writes = nil
+
+	return nil
+}
+
+// Returns the written keys in
+// the ascending order so the
+// commit is deterministic
+func (txn *Txn[TKey, TValue]) keys() []TKey {
+	keys := make([]TKey, 0, len(txn.writes))
+
+	for key := range txn.writes {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+
+	return keys
+}
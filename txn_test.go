@@ -0,0 +1,174 @@
+package avltree_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zergon321/go-avltree"
+	"github.com/zergon321/mempool"
+)
+
+func TestTxnCommit(t *testing.T) {
+	tree, err := avltree.NewAVLTree[int, string]()
+	assert.Nil(t, err)
+
+	tree.Add(1, "a")
+	tree.Add(2, "b")
+
+	txn := tree.Begin()
+	assert.Nil(t, txn.Add(3, "c"))
+	assert.Nil(t, txn.Remove(1))
+	assert.Nil(t, txn.AddOrUpdate(2, "", func(oldValue string) (string, error) {
+		return oldValue + "b", nil
+	}))
+
+	value, ok := txn.Get(2)
+	assert.True(t, ok)
+	assert.Equal(t, "bb", value)
+
+	_, ok = txn.Get(1)
+	assert.False(t, ok)
+
+	// the tree doesn't see the writes yet
+	assert.Nil(t, tree.Search(3))
+	assert.Equal(t, "b", tree.Search(2).Value)
+
+	assert.Nil(t, txn.Commit())
+	assert.Nil(t, tree.Search(1))
+	assert.Equal(t, "bb", tree.Search(2).Value)
+	assert.Equal(t, "c", tree.Search(3).Value)
+	assert.Nil(t, tree.Validate())
+
+	var closedErr *avltree.ErrorTxnClosed
+	assert.ErrorAs(t, txn.Add(4, "d"), &closedErr)
+	assert.ErrorAs(t, txn.Commit(), &closedErr)
+}
+
+func TestTxnRollback(t *testing.T) {
+	created := 0
+	pool, err := mempool.NewPool(func() *avltree.AVLNode[int, int] {
+		created++
+		return &avltree.AVLNode[int, int]{}
+	})
+	assert.Nil(t, err)
+
+	tree, err := avltree.NewAVLTree(avltree.AVLTreeOptionWithMemoryPool(pool))
+	assert.Nil(t, err)
+
+	tree.Add(0, 0)
+
+	txn := tree.Begin()
+
+	for i := 1; i <= 10; i++ {
+		assert.Nil(t, txn.Add(i, i))
+	}
+
+	errFailed := errors.New("failed")
+	err = txn.AddOrUpdate(0, 0, func(oldValue int) (int, error) {
+		return 0, errFailed
+	})
+	assert.ErrorIs(t, err, errFailed)
+
+	assert.Nil(t, txn.Rollback())
+
+	for i := 1; i <= 10; i++ {
+		assert.Nil(t, tree.Search(i))
+	}
+
+	// the nodes taken by the transaction
+	// are back in the pool
+	for i := 1; i <= 10; i++ {
+		tree.Add(i, i)
+	}
+
+	assert.Equal(t, 11, created)
+}
+
+func TestTxnCommitWithEviction(t *testing.T) {
+	var tree *avltree.AVLTree[int, int]
+	sizes := []int{}
+	tree, err := avltree.NewAVLTree(
+		avltree.AVLTreeOptionWithMaxSize[int, int](2, avltree.EvictLargest),
+		avltree.AVLTreeOptionOnInsert(func(key, value int) {
+			sizes = append(sizes, tree.Len())
+		}))
+	assert.Nil(t, err)
+
+	tree.Add(1, 10)
+	tree.Add(5, 5)
+
+	// the bound would evict 5
+	// written by the transaction
+	txn := tree.Begin()
+	assert.Nil(t, txn.Add(3, 30))
+	assert.Nil(t, txn.Add(5, 50))
+	var evictsErr *avltree.ErrorTxnEvictsOwnWrite
+	assert.ErrorAs(t, txn.Commit(), &evictsErr)
+	assert.Equal(t, []int{1, 5}, treeKeys(tree))
+	assert.Equal(t, 5, tree.Search(5).Value)
+
+	var closedErr *avltree.ErrorTxnClosed
+	assert.ErrorAs(t, txn.Add(4, 4), &closedErr)
+
+	// the hooks see the whole batch
+	// applied before the eviction
+	sizes = sizes[:0]
+	txn = tree.Begin()
+	assert.Nil(t, txn.Add(0, 0))
+	assert.Nil(t, txn.Add(-1, -1))
+	assert.Nil(t, txn.Commit())
+	assert.Equal(t, []int{-1, 0}, treeKeys(tree))
+	assert.Equal(t, []int{4, 4}, sizes)
+	assert.Nil(t, tree.Validate())
+}
+
+func TestTxnCommitWithLRUEviction(t *testing.T) {
+	tree, err := avltree.NewAVLTree(
+		avltree.AVLTreeOptionWithMaxSize[int, int](2, avltree.EvictLRU))
+	assert.Nil(t, err)
+
+	tree.Add(1, 1)
+	tree.Add(2, 2)
+
+	txn := tree.Begin()
+	assert.Nil(t, txn.Add(3, 3))
+	assert.Nil(t, txn.Commit())
+	assert.Equal(t, []int{2, 3}, treeKeys(tree))
+
+	// three written entries
+	// can't fit into two
+	txn = tree.Begin()
+
+	for i := 4; i <= 6; i++ {
+		assert.Nil(t, txn.Add(i, i))
+	}
+
+	var evictsErr *avltree.ErrorTxnEvictsOwnWrite
+	assert.ErrorAs(t, txn.Commit(), &evictsErr)
+	assert.Equal(t, []int{2, 3}, treeKeys(tree))
+}
+
+func TestTxnCommitWithEvictionFunc(t *testing.T) {
+	tree, err := avltree.NewAVLTree(
+		avltree.AVLTreeOptionWithEvictionFunc(2, func(tree *avltree.AVLTree[int, int], key int) int {
+			return 1
+		}))
+	assert.Nil(t, err)
+
+	tree.Add(1, 1)
+
+	// the victims can't be foreseen, so
+	// exceeding the bound isn't allowed
+	txn := tree.Begin()
+	assert.Nil(t, txn.Add(2, 2))
+	assert.Nil(t, txn.Add(3, 3))
+	var evictsErr *avltree.ErrorTxnEvictsOwnWrite
+	assert.ErrorAs(t, txn.Commit(), &evictsErr)
+	assert.Equal(t, []int{1}, treeKeys(tree))
+
+	txn = tree.Begin()
+	assert.Nil(t, txn.Add(2, 2))
+	assert.Nil(t, txn.Commit())
+	assert.Equal(t, []int{1, 2}, treeKeys(tree))
+}
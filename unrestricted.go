@@ -9,46 +9,103 @@ import (
 // AVLTree[TKey constraints.Ordered, TValue any] structure. Public methods are Add, Remove, Update, Search, DisplayTreeInOrder.
 type UnrestrictedAVLTree[TKey Comparable, TValue any] struct {
 	root *UnrestrictedAVLNode[TKey, TValue]
-	pool *mempool.Pool[*UnrestrictedAVLNode[TKey, TValue]]
+	pool NodeAllocator[*UnrestrictedAVLNode[TKey, TValue]]
+	// path is the scratch stack
+	// of child slots used by writes.
+	path []**UnrestrictedAVLNode[TKey, TValue]
+	// parentLinks enables maintaining
+	// the parent pointers of the nodes.
+	parentLinks bool
+	// size is the number
+	// of nodes in the tree.
+	size int
+	// encode turns the entries into bytes
+	// for hashing. If it's nil, the nodes
+	// don't maintain hashes.
+	encode func(key TKey, value TValue) []byte
+	// watchers receive the changes
+	// of the key ranges.
+	watchers []*Watcher[TKey, TValue]
+	// hooks is nil if no
+	// hooks are registered.
+	hooks *hooks[TKey, TValue]
+	// counters accumulate
+	// the statistics.
+	counters treeCounters
 }
 
+// Erase returns all the nodes
+// to the pool and resets the tree.
 func (t *UnrestrictedAVLTree[TKey, TValue]) Erase() error {
-	t.root = nil
+	t.Clear()
 	t.pool = nil
 
 	return nil
 }
 
 func (t *UnrestrictedAVLTree[TKey, TValue]) SetPool(pool *mempool.Pool[*UnrestrictedAVLNode[TKey, TValue]]) {
-	t.pool = pool
+	t.pool = MempoolAllocator(pool)
+}
+
+// SetAllocator makes the tree take the new
+// nodes from the allocator and put the removed
+// nodes back to it. The nodes currently in
+// the tree must not be owned by a different
+// allocator.
+func (t *UnrestrictedAVLTree[TKey, TValue]) SetAllocator(alloc NodeAllocator[*UnrestrictedAVLNode[TKey, TValue]]) {
+	t.pool = alloc
 }
 
 func (t *UnrestrictedAVLTree[TKey, TValue]) Add(key TKey, value TValue) {
-	t.root = t.root.add(key, value, t.pool)
+	t.add(key, value)
 }
 
+// AddOrUpdate adds the value by the key if there's no such key
+// in the tree. Otherwise it sets the value returned by upd for
+// the current value. If upd fails, the tree stays unchanged.
 func (t *UnrestrictedAVLTree[TKey, TValue]) AddOrUpdate(
 	key TKey, value TValue,
 	upd func(oldValue TValue) (TValue, error),
 ) error {
-	root, err := t.root.addOrUpdate(key, value, upd, t.pool)
+	path, slot := t.find(key)
+
+	if node := *slot; node != nil {
+		// if same key exists update value
+		value, err := upd(node.Value)
+
+		if err != nil {
+			return err
+		}
 
-	if err != nil {
-		return err
+		t.setValue(path, slot, value)
+
+		return nil
 	}
 
-	t.root = root
+	t.attach(path, slot, t.newNode(key, value))
 
 	return nil
 }
 
 func (t *UnrestrictedAVLTree[TKey, TValue]) Remove(key TKey) {
-	t.root = t.root.remove(key, t.pool)
+	t.remove(key)
+}
+
+// Update moves the entry of the old key to the new key
+// and sets the new value. The node of the old key is reused
+// for the new key. If the new key already exists, its value
+// is overwritten. Returns false and leaves the tree unchanged
+// if there's no old key in the tree.
+func (t *UnrestrictedAVLTree[TKey, TValue]) Update(oldKey TKey, newKey TKey, newValue TValue) bool {
+	existed, _ := t.update(oldKey, newKey, newValue, false)
+	return existed
 }
 
-func (t *UnrestrictedAVLTree[TKey, TValue]) Update(oldKey TKey, newKey TKey, newValue TValue) {
-	t.root = t.root.remove(oldKey, t.pool)
-	t.root = t.root.add(newKey, newValue, t.pool)
+// UpdateStrict works like Update but fails with
+// ErrorKeyExists leaving the tree unchanged
+// if the new key already exists.
+func (t *UnrestrictedAVLTree[TKey, TValue]) UpdateStrict(oldKey TKey, newKey TKey, newValue TValue) (bool, error) {
+	return t.update(oldKey, newKey, newValue, true)
 }
 
 func (t *UnrestrictedAVLTree[TKey, TValue]) Search(key TKey) (node *UnrestrictedAVLNode[TKey, TValue]) {
@@ -56,39 +113,251 @@ func (t *UnrestrictedAVLTree[TKey, TValue]) Search(key TKey) (node *Unrestricted
 }
 
 func (t *UnrestrictedAVLTree[TKey, TValue]) VisitInOrder(visit func(node *UnrestrictedAVLNode[TKey, TValue]) error) error {
-	return t.visitInOrder(t.root, visit)
+	return t.visitInOrder(visit)
 }
 
-func (t *UnrestrictedAVLTree[TKey, TValue]) visitInOrder(node *UnrestrictedAVLNode[TKey, TValue], visit func(node *UnrestrictedAVLNode[TKey, TValue]) error) error {
-	if node == nil {
-		return nil
-	}
+// Visits the nodes in the ascending order of keys
+func (t *UnrestrictedAVLTree[TKey, TValue]) visitInOrder(visit func(node *UnrestrictedAVLNode[TKey, TValue]) error) error {
+	var stack [maxHeight]*UnrestrictedAVLNode[TKey, TValue]
+	top := 0
+	node := t.root
+
+	for node != nil || top > 0 {
+		for node != nil {
+			stack[top] = node
+			top++
+			node = node.left
+		}
 
-	if node.left != nil {
-		err := t.visitInOrder(node.left, visit)
+		top--
+		node = stack[top]
+
+		err := visit(node)
 
 		if err != nil {
 			return err
 		}
+
+		node = node.right
 	}
 
-	if node != nil {
-		err := visit(node)
+	return nil
+}
 
-		if err != nil {
-			return err
+// Returns the empty scratch path stack of the tree
+func (t *UnrestrictedAVLTree[TKey, TValue]) pathStack() []**UnrestrictedAVLNode[TKey, TValue] {
+	if t.path == nil {
+		t.path = make([]**UnrestrictedAVLNode[TKey, TValue], 0, maxHeight)
+	}
+
+	return t.path[:0]
+}
+
+// Walks down from the root looking for the key. Returns
+// the path of slots leading to the slot which either
+// references the node with the key or is empty
+func (t *UnrestrictedAVLTree[TKey, TValue]) find(key TKey) ([]**UnrestrictedAVLNode[TKey, TValue], **UnrestrictedAVLNode[TKey, TValue]) {
+	path := t.pathStack()
+	slot := &t.root
+
+	for *slot != nil {
+		n := *slot
+
+		if key.Less(n.key) {
+			path = append(path, slot)
+			slot = &n.left
+		} else if key.Greater(n.key) {
+			path = append(path, slot)
+			slot = &n.right
+		} else {
+			break
 		}
 	}
 
-	if node.right != nil {
-		err := t.visitInOrder(node.right, visit)
+	return path, slot
+}
 
-		if err != nil {
-			return err
+// Attaches the node to the empty slot
+// and then rebalances the path bottom-up
+func (t *UnrestrictedAVLTree[TKey, TValue]) attach(path []**UnrestrictedAVLNode[TKey, TValue], slot **UnrestrictedAVLNode[TKey, TValue], node *UnrestrictedAVLNode[TKey, TValue]) {
+	*slot = node
+	t.size++
+
+	if t.parentLinks && len(path) > 0 {
+		node.parent = *path[len(path)-1]
+	}
+
+	t.rebalancePath(path)
+
+	var zeroValTValue TValue
+	t.notify(EventInsert, node.key, zeroValTValue, node.Value)
+}
+
+// Adds a new node or updates
+// the value of the existing one
+func (t *UnrestrictedAVLTree[TKey, TValue]) add(key TKey, value TValue) {
+	path, slot := t.find(key)
+
+	if *slot != nil {
+		// if same key exists update value
+		t.setValue(path, slot, value)
+		return
+	}
+
+	t.attach(path, slot, t.newNode(key, value))
+}
+
+// Removes a node and returns it to the pool
+func (t *UnrestrictedAVLTree[TKey, TValue]) remove(key TKey) {
+	path, slot := t.find(key)
+
+	if *slot == nil {
+		return
+	}
+
+	t.release(t.unlink(path, slot))
+}
+
+// Moves the node of the old key to the new key
+func (t *UnrestrictedAVLTree[TKey, TValue]) update(oldKey TKey, newKey TKey, newValue TValue, strict bool) (bool, error) {
+	path, slot := t.find(oldKey)
+	node := *slot
+
+	if node == nil {
+		return false, nil
+	}
+
+	if !newKey.Less(oldKey) && !newKey.Greater(oldKey) {
+		t.setValue(path, slot, newValue)
+		return true, nil
+	}
+
+	if strict && t.root.search(newKey) != nil {
+		return true, &ErrorKeyExists{
+			key: newKey,
 		}
 	}
 
-	return nil
+	node = t.unlink(path, slot)
+	node.key = newKey
+	node.Value = newValue
+	node.height = 1
+	t.hashEntry(node)
+
+	path, slot = t.find(newKey)
+
+	if *slot != nil {
+		t.setValue(path, slot, newValue)
+		t.release(node)
+
+		return true, nil
+	}
+
+	t.attach(path, slot, node)
+
+	return true, nil
+}
+
+// Unlinks the node referenced by the slot and rebalances
+// the path leading to the slot. The node is relinked
+// rather than copied so handles to the other nodes stay
+// valid. It's returned detached but not released
+func (t *UnrestrictedAVLTree[TKey, TValue]) unlink(path []**UnrestrictedAVLNode[TKey, TValue], slot **UnrestrictedAVLNode[TKey, TValue]) *UnrestrictedAVLNode[TKey, TValue] {
+	node := *slot
+
+	if node.left != nil && node.right != nil {
+		// node to delete found with both children;
+		// replace it with smallest node of the right sub-tree
+		target := len(path)
+		path = append(path, slot)
+		minSlot := &node.right
+
+		for (*minSlot).left != nil {
+			path = append(path, minSlot)
+			minSlot = &(*minSlot).left
+		}
+
+		rightMinNode := *minSlot
+		*minSlot = rightMinNode.right
+
+		if t.parentLinks && rightMinNode.right != nil {
+			rightMinNode.right.parent = rightMinNode.parent
+		}
+
+		rightMinNode.left = node.left
+		rightMinNode.right = node.right
+		rightMinNode.height = node.height
+		*slot = rightMinNode
+
+		if t.parentLinks {
+			rightMinNode.parent = node.parent
+			rightMinNode.left.parent = rightMinNode
+
+			if rightMinNode.right != nil {
+				rightMinNode.right.parent = rightMinNode
+			}
+		}
+
+		// the path went through the right
+		// child slot of the removed node
+		if len(path) > target+1 {
+			path[target+1] = &rightMinNode.right
+		}
+	} else {
+		// node has at most one child
+		// which takes its place
+		child := node.left
+
+		if child == nil {
+			child = node.right
+		}
+
+		*slot = child
+
+		if t.parentLinks && child != nil {
+			child.parent = node.parent
+		}
+	}
+
+	t.rebalancePath(path)
+	t.size--
+
+	node.left = nil
+	node.right = nil
+	node.parent = nil
+
+	var zeroValTValue TValue
+	t.notify(EventDelete, node.key, node.Value, zeroValTValue)
+
+	return node
+}
+
+// Returns the node to the pool if it's set.
+// The error of the pool is ignored: the node
+// is out of the tree and the GC takes it
+func (t *UnrestrictedAVLTree[TKey, TValue]) release(node *UnrestrictedAVLNode[TKey, TValue]) {
+	if t.pool != nil {
+		t.pool.Put(node)
+		t.counters.pooled++
+	}
+}
+
+// Clear removes all the nodes from
+// the tree returning them to the pool.
+func (t *UnrestrictedAVLTree[TKey, TValue]) Clear() {
+	root := t.root
+	t.root = nil
+	t.size = 0
+
+	if t.pool != nil || len(t.watchers) > 0 || t.hooks != nil {
+		t.releaseAll(root)
+	}
+}
+
+// Len returns the number
+// of entries in the tree.
+func (t *UnrestrictedAVLTree[TKey, TValue]) Len() int {
+	return t.size
 }
 
 func (t *UnrestrictedAVLTree[TKey, TValue]) DisplayInOrder() {
@@ -104,6 +373,12 @@ type UnrestrictedAVLNode[TKey Comparable, TValue any] struct {
 	height int
 	left   *UnrestrictedAVLNode[TKey, TValue]
 	right  *UnrestrictedAVLNode[TKey, TValue]
+	// parent is only maintained if the tree
+	// is created with parent links enabled.
+	parent *UnrestrictedAVLNode[TKey, TValue]
+	// hash is only maintained if the tree
+	// is created with hashing enabled.
+	hash *nodeHash
 }
 
 // Key returns the key of the AVL tree node.
@@ -124,146 +399,84 @@ func (node *UnrestrictedAVLNode[TKey, TValue]) Erase() error {
 	node.height = 0
 	node.left = nil
 	node.right = nil
+	node.parent = nil
+
+	// the hash is kept so the pooled
+	// nodes don't reallocate it
+	if node.hash != nil {
+		*node.hash = nodeHash{}
+	}
 
 	return nil
 }
 
-// Adds a new node
-func (n *UnrestrictedAVLNode[TKey, TValue]) add(key TKey, value TValue, pool *mempool.Pool[*UnrestrictedAVLNode[TKey, TValue]]) *UnrestrictedAVLNode[TKey, TValue] {
-	if n == nil {
-		if pool != nil {
-			node := pool.Get()
-
-			node.key = key
-			node.Value = value
-			node.height = 1
-
-			return node
-		}
-
-		return &UnrestrictedAVLNode[TKey, TValue]{key, value, 1, nil, nil}
+// Next returns the node with the next key
+// in the tree or nil if the node is the last one.
+// The tree must be created with parent links enabled.
+func (node *UnrestrictedAVLNode[TKey, TValue]) Next() *UnrestrictedAVLNode[TKey, TValue] {
+	if node.right != nil {
+		return node.right.findSmallest()
 	}
 
-	if key.Less(n.key) {
-		n.left = n.left.add(key, value, pool)
-	} else if key.Greater(n.key) {
-		n.right = n.right.add(key, value, pool)
-	} else {
-		// if same key exists update value
-		n.Value = value
+	for node.parent != nil && node == node.parent.right {
+		node = node.parent
 	}
-	return n.rebalanceTree()
-}
-
-func (n *UnrestrictedAVLNode[TKey, TValue]) addOrUpdate(
-	key TKey, value TValue,
-	upd func(oldValue TValue) (TValue, error),
-	pool *mempool.Pool[*UnrestrictedAVLNode[TKey, TValue]],
-) (*UnrestrictedAVLNode[TKey, TValue], error) {
-	var err error
-
-	if n == nil {
-		if pool != nil {
-			node := pool.Get()
 
-			node.key = key
-			node.Value = value
-			node.height = 1
+	return node.parent
+}
 
-			return node, nil
-		}
+// Prev returns the node with the previous key
+// in the tree or nil if the node is the first one.
+// The tree must be created with parent links enabled.
+func (node *UnrestrictedAVLNode[TKey, TValue]) Prev() *UnrestrictedAVLNode[TKey, TValue] {
+	if node.left != nil {
+		return node.left.findLargest()
+	}
 
-		return &UnrestrictedAVLNode[TKey, TValue]{key, value, 1, nil, nil}, nil
+	for node.parent != nil && node == node.parent.left {
+		node = node.parent
 	}
 
-	if key.Less(n.key) {
-		n.left, err = n.left.addOrUpdate(key, value, upd, pool)
+	return node.parent
+}
 
-		if err != nil {
-			return nil, err
-		}
-	} else if key.Greater(n.key) {
-		n.right, err = n.right.addOrUpdate(key, value, upd, pool)
+// Creates a new node taking it from the pool if any
+func (t *UnrestrictedAVLTree[TKey, TValue]) newNode(key TKey, value TValue) *UnrestrictedAVLNode[TKey, TValue] {
+	if t.pool != nil {
+		node := t.pool.Get()
+		t.counters.taken()
 
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		// if same key exists update value
-		value, err := upd(n.Value)
+		node.key = key
+		node.Value = value
+		node.height = 1
+		t.hashEntry(node)
 
-		if err != nil {
-			return nil, err
-		}
+		return node
+	}
 
-		n.Value = value
+	node := &UnrestrictedAVLNode[TKey, TValue]{
+		key:    key,
+		Value:  value,
+		height: 1,
 	}
+	t.hashEntry(node)
 
-	return n.rebalanceTree(), nil
+	return node
 }
 
-// Removes a node
-func (n *UnrestrictedAVLNode[TKey, TValue]) remove(key TKey, pool *mempool.Pool[*UnrestrictedAVLNode[TKey, TValue]]) *UnrestrictedAVLNode[TKey, TValue] {
-	if n == nil {
-		return nil
-	}
-	if key.Less(n.key) {
-		n.left = n.left.remove(key, pool)
-	} else if key.Greater(n.key) {
-		n.right = n.right.remove(key, pool)
-	} else {
-		if n.left != nil && n.right != nil {
-			// node to delete found with both children;
-			// replace values with smallest node of the right sub-tree
-			rightMinNode := n.right.findSmallest()
-			n.key = rightMinNode.key
-			n.Value = rightMinNode.Value
-			// delete smallest node that we replaced
-			n.right = n.right.remove(rightMinNode.key, pool)
-		} else if n.left != nil {
-			// node only has left child
-			node := n
+// Searches for a node
+func (n *UnrestrictedAVLNode[TKey, TValue]) search(key TKey) *UnrestrictedAVLNode[TKey, TValue] {
+	for n != nil {
+		if key.Less(n.key) {
 			n = n.left
-
-			if pool != nil {
-				pool.Put(node)
-			}
-		} else if n.right != nil {
-			// node only has right child
-			node := n
+		} else if key.Greater(n.key) {
 			n = n.right
-
-			if pool != nil {
-				pool.Put(node)
-			}
 		} else {
-			// node has no children
-			node := n
-			n = nil
-
-			if pool != nil {
-				pool.Put(node)
-			}
-
 			return n
 		}
-
 	}
-	return n.rebalanceTree()
-}
 
-// Searches for a node
-func (n *UnrestrictedAVLNode[TKey, TValue]) search(key TKey) *UnrestrictedAVLNode[TKey, TValue] {
-	if n == nil {
-		return nil
-	}
-	if key.Less(n.key) {
-		return n.left.search(key)
-	} else if key.Greater(n.key) {
-		return n.right.search(key)
-	} else {
-		return n
-	}
+	return nil
 }
 
 // Displays nodes left-depth first (used for debugging)
@@ -288,50 +501,109 @@ func (n *UnrestrictedAVLNode[TKey, TValue]) recalculateHeight() {
 	n.height = 1 + maxElem(n.left.getHeight(), n.right.getHeight())
 }
 
+// Rebalances the nodes referenced by the path slots
+// starting from the deepest one. Stops as soon as
+// the height of a subtree stays the same because
+// nothing changes for its ancestors in that case
+// unless their hashes have to be recalculated
+func (t *UnrestrictedAVLTree[TKey, TValue]) rebalancePath(path []**UnrestrictedAVLNode[TKey, TValue]) {
+	for i := len(path) - 1; i >= 0; i-- {
+		height := (*path[i]).height
+		*path[i] = t.rebalance(*path[i])
+
+		if (*path[i]).height == height && t.encode == nil {
+			break
+		}
+	}
+
+	for i := range path {
+		path[i] = nil
+	}
+}
+
 // Checks if node is balanced and rebalance
-func (n *UnrestrictedAVLNode[TKey, TValue]) rebalanceTree() *UnrestrictedAVLNode[TKey, TValue] {
+func (t *UnrestrictedAVLTree[TKey, TValue]) rebalance(n *UnrestrictedAVLNode[TKey, TValue]) *UnrestrictedAVLNode[TKey, TValue] {
 	if n == nil {
 		return n
 	}
 	n.recalculateHeight()
+	n.recalculateHash()
 
 	// check balance factor and rotateLeft if right-heavy and rotateRight if left-heavy
 	balanceFactor := n.left.getHeight() - n.right.getHeight()
 	if balanceFactor == -2 {
 		// check if child is left-heavy and rotateRight first
 		if n.right.left.getHeight() > n.right.right.getHeight() {
-			n.right = n.right.rotateRight()
+			t.counters.doubleRotations++
+			n.right = t.rotateRight(n.right)
+		} else {
+			t.counters.singleRotations++
 		}
-		return n.rotateLeft()
+		return t.rotateLeft(n)
 	} else if balanceFactor == 2 {
 		// check if child is right-heavy and rotateLeft first
 		if n.left.right.getHeight() > n.left.left.getHeight() {
-			n.left = n.left.rotateLeft()
+			t.counters.doubleRotations++
+			n.left = t.rotateLeft(n.left)
+		} else {
+			t.counters.singleRotations++
 		}
-		return n.rotateRight()
+		return t.rotateRight(n)
 	}
 	return n
 }
 
 // Rotate nodes left to balance node
-func (n *UnrestrictedAVLNode[TKey, TValue]) rotateLeft() *UnrestrictedAVLNode[TKey, TValue] {
+func (t *UnrestrictedAVLTree[TKey, TValue]) rotateLeft(n *UnrestrictedAVLNode[TKey, TValue]) *UnrestrictedAVLNode[TKey, TValue] {
 	newRoot := n.right
 	n.right = newRoot.left
 	newRoot.left = n
 
+	if t.parentLinks {
+		if n.right != nil {
+			n.right.parent = n
+		}
+
+		newRoot.parent = n.parent
+		n.parent = newRoot
+	}
+
 	n.recalculateHeight()
 	newRoot.recalculateHeight()
+	n.recalculateHash()
+	newRoot.recalculateHash()
+
+	if t.hooks != nil {
+		t.hooks.rotated(n.key, newRoot.key)
+	}
+
 	return newRoot
 }
 
 // Rotate nodes right to balance node
-func (n *UnrestrictedAVLNode[TKey, TValue]) rotateRight() *UnrestrictedAVLNode[TKey, TValue] {
+func (t *UnrestrictedAVLTree[TKey, TValue]) rotateRight(n *UnrestrictedAVLNode[TKey, TValue]) *UnrestrictedAVLNode[TKey, TValue] {
 	newRoot := n.left
 	n.left = newRoot.right
 	newRoot.right = n
 
+	if t.parentLinks {
+		if n.left != nil {
+			n.left.parent = n
+		}
+
+		newRoot.parent = n.parent
+		n.parent = newRoot
+	}
+
 	n.recalculateHeight()
 	newRoot.recalculateHeight()
+	n.recalculateHash()
+	newRoot.recalculateHash()
+
+	if t.hooks != nil {
+		t.hooks.rotated(n.key, newRoot.key)
+	}
+
 	return newRoot
 }
 
@@ -344,6 +616,15 @@ func (n *UnrestrictedAVLNode[TKey, TValue]) findSmallest() *UnrestrictedAVLNode[
 	}
 }
 
+// Finds the largest child (based on the key) for the current node
+func (n *UnrestrictedAVLNode[TKey, TValue]) findLargest() *UnrestrictedAVLNode[TKey, TValue] {
+	for n.right != nil {
+		n = n.right
+	}
+
+	return n
+}
+
 // NewAVLTree creates a new
 // AVL tree with the specified options.
 func NewUnrestrictedAVLTree[
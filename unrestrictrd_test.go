@@ -1,6 +1,7 @@
 package avltree_test
 
 import (
+	"errors"
 	"strconv"
 	"testing"
 
@@ -119,3 +120,52 @@ func TestRangeTreeSearch(t *testing.T) {
 	node = tree.Search(Point{Num: 1.5})
 	assert.Nil(t, node)
 }
+
+func TestRangeTreeNext(t *testing.T) {
+	tree, err := avltree.NewUnrestrictedAVLTree(
+		avltree.UnrestrictedAVLTreeOptionWithParentLinks[Geometric, struct{}]())
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i += 2 {
+		r := Range{A: float32(i), B: float32(i + 1)}
+		tree.Add(r, struct{}{})
+	}
+
+	node := tree.Search(Point{Num: 2.5})
+	assert.NotNil(t, node)
+
+	node = node.Next()
+	assert.Equal(t, Range{A: 4, B: 5}, node.Key())
+
+	node = node.Prev().Prev()
+	assert.Equal(t, Range{A: 0, B: 1}, node.Key())
+	assert.Nil(t, node.Prev())
+}
+
+func TestUnrestrictedAddOrUpdateFailureLeavesTreeUnchanged(t *testing.T) {
+	tree, err := avltree.NewUnrestrictedAVLTree[IntKey, int]()
+	assert.Nil(t, err)
+
+	for i := 0; i < 127; i++ {
+		tree.Add(IntKey(i), i)
+	}
+
+	errFailed := errors.New("failed")
+	fail := func(oldValue int) (int, error) {
+		return 0, errFailed
+	}
+
+	// every key sits at a different
+	// depth of the complete tree
+	for i := 0; i < 127; i++ {
+		err = tree.AddOrUpdate(IntKey(i), -1, fail)
+		assert.ErrorIs(t, err, errFailed)
+		assert.Nil(t, tree.Validate())
+
+		for j := 0; j < 127; j++ {
+			node := tree.Search(IntKey(j))
+			assert.NotNil(t, node)
+			assert.Equal(t, j, node.Value)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package avltree
+
+// Action tells Upsert what
+// to do with the entry.
+type Action int
+
+const (
+	// ActionNone leaves
+	// the tree unchanged.
+	ActionNone Action = iota
+	// ActionPut inserts the entry
+	// or updates its value.
+	ActionPut
+	// ActionDelete removes the
+	// entry if it exists.
+	ActionDelete
+)
+
+// Upsert looks the key up and calls fn with the current value
+// and whether it exists. Depending on the action returned by fn
+// the entry is inserted, updated, deleted or left as is, all in
+// a single descent. If fn fails, the tree stays unchanged.
+// fn must not modify the tree.
+func (t *AVLTree[TKey, TValue]) Upsert(
+	key TKey,
+	fn func(oldValue TValue, exists bool) (TValue, Action, error),
+) error {
+	var oldValue TValue
+	path, slot := t.find(key)
+	node := *slot
+
+	if node != nil {
+		oldValue = node.Value
+	}
+
+	value, action, err := fn(oldValue, node != nil)
+
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case ActionNone:
+
+	case ActionPut:
+		if node != nil {
+			t.setValue(path, slot, value)
+		} else {
+			t.attach(path, slot, t.newNode(key, value))
+		}
+
+	case ActionDelete:
+		if node != nil {
+			t.release(t.unlink(path, slot))
+		}
+
+	default:
+		return &ErrorUnknownAction{
+			action: action,
+		}
+	}
+
+	return nil
+}
+
+// GetOrInsert returns a pointer to the value stored by the key.
+// If there's no such key, the value returned by factory is inserted
+// first. The second return value is true if the key already existed.
+// The pointer stays valid until the key is removed from the tree
+// or, if a snapshot of the tree is taken, until the next write
+// to the tree (see Snapshot).
+// If the inserted entry is evicted at once by the size bound,
+// the pointer refers to a detached copy of the value.
+// Writing through the pointer bypasses the hashes, the watchers
+// and the hooks, so the value must be changed by the tree methods
+// if any of them is enabled.
+func (t *AVLTree[TKey, TValue]) GetOrInsert(key TKey, factory func() TValue) (*TValue, bool) {
+	path, slot := t.find(key)
+
+	if node := *slot; node != nil {
+		// getting uses the entry
+		// for the LRU eviction
+		if t.bound != nil && t.bound.elements != nil {
+			t.bound.touch(key)
+		}
+
+		return &node.Value, true
+	}
+
+	value := factory()
+	node := t.newNode(key, value)
+	t.attach(path, slot, node)
+
+	if t.bound != nil && t.root.search(key) != node {
+		return &value, false
+	}
+
+	return &node.Value, false
+}
+
+// Upsert looks the key up and calls fn with the current value
+// and whether it exists. Depending on the action returned by fn
+// the entry is inserted, updated, deleted or left as is, all in
+// a single descent. If fn fails, the tree stays unchanged.
+// fn must not modify the tree.
+func (t *UnrestrictedAVLTree[TKey, TValue]) Upsert(
+	key TKey,
+	fn func(oldValue TValue, exists bool) (TValue, Action, error),
+) error {
+	var oldValue TValue
+	path, slot := t.find(key)
+	node := *slot
+
+	if node != nil {
+		oldValue = node.Value
+	}
+
+	value, action, err := fn(oldValue, node != nil)
+
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case ActionNone:
+
+	case ActionPut:
+		if node != nil {
+			t.setValue(path, slot, value)
+		} else {
+			t.attach(path, slot, t.newNode(key, value))
+		}
+
+	case ActionDelete:
+		if node != nil {
+			t.release(t.unlink(path, slot))
+		}
+
+	default:
+		return &ErrorUnknownAction{
+			action: action,
+		}
+	}
+
+	return nil
+}
+
+// GetOrInsert returns a pointer to the value stored by the key.
+// If there's no such key, the value returned by factory is inserted
+// first. The second return value is true if the key already existed.
+// The pointer stays valid until the key is removed from the tree.
+// Writing through the pointer bypasses the hashes, the watchers
+// and the hooks, so the value must be changed by the tree methods
+// if any of them is enabled.
+func (t *UnrestrictedAVLTree[TKey, TValue]) GetOrInsert(key TKey, factory func() TValue) (*TValue, bool) {
+	path, slot := t.find(key)
+
+	if node := *slot; node != nil {
+		return &node.Value, true
+	}
+
+	node := t.newNode(key, factory())
+	t.attach(path, slot, node)
+
+	return &node.Value, false
+}
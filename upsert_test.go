@@ -0,0 +1,74 @@
+package avltree_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zergon321/go-avltree"
+)
+
+func TestUpsert(t *testing.T) {
+	tree, err := avltree.NewAVLTree(
+		avltree.AVLTreeOptionWithParentLinks[int, int]())
+	assert.Nil(t, err)
+
+	counter := func(oldValue int, exists bool) (int, avltree.Action, error) {
+		if oldValue >= 2 {
+			return 0, avltree.ActionDelete, nil
+		}
+
+		return oldValue + 1, avltree.ActionPut, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		for key := 0; key < 10; key++ {
+			err = tree.Upsert(key, counter)
+			assert.Nil(t, err)
+		}
+
+		assert.Nil(t, tree.Validate())
+	}
+
+	for key := 0; key < 10; key++ {
+		assert.Nil(t, tree.Search(key))
+	}
+
+	err = tree.Upsert(1, func(oldValue int, exists bool) (int, avltree.Action, error) {
+		assert.False(t, exists)
+		return 0, avltree.ActionNone, nil
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, tree.Search(1))
+
+	errFailed := errors.New("failed")
+	err = tree.Upsert(1, func(oldValue int, exists bool) (int, avltree.Action, error) {
+		return 1, avltree.ActionPut, errFailed
+	})
+	assert.ErrorIs(t, err, errFailed)
+	assert.Nil(t, tree.Search(1))
+
+	err = tree.Upsert(1, func(oldValue int, exists bool) (int, avltree.Action, error) {
+		return 1, avltree.Action(100), nil
+	})
+
+	var actionErr *avltree.ErrorUnknownAction
+	assert.ErrorAs(t, err, &actionErr)
+}
+
+func TestGetOrInsert(t *testing.T) {
+	tree, err := avltree.NewUnrestrictedAVLTree[IntKey, []int]()
+	assert.Nil(t, err)
+
+	for i := 0; i < 20; i++ {
+		values, loaded := tree.GetOrInsert(IntKey(i%5), func() []int {
+			return []int{}
+		})
+		assert.Equal(t, i >= 5, loaded)
+
+		*values = append(*values, i)
+	}
+
+	assert.Equal(t, []int{3, 8, 13, 18}, tree.Search(3).Value)
+	assert.Nil(t, tree.Validate())
+}
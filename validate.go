@@ -0,0 +1,611 @@
+package avltree
+
+import "fmt"
+
+// Validate checks the structural invariants of the tree:
+// BST ordering of the keys, stored heights, balance factors,
+// parent links and subtree hashes (if enabled), the tree size
+// and absence of nodes reachable more than once. It's meant
+// to be used in tests and debug builds.
+func (t *AVLTree[TKey, TValue]) Validate() error {
+	visited := map[*AVLNode[TKey, TValue]]struct{}{}
+	_, err := t.root.validate("root", nil, nil, nil, t.parentLinks, visited)
+
+	if err != nil {
+		return err
+	}
+
+	if len(visited) != t.size {
+		return &ErrorInvalidTree{
+			path:   "root",
+			reason: fmt.Sprintf("tree size is %d, actual number of nodes is %d", t.size, len(visited)),
+		}
+	}
+
+	return nil
+}
+
+func (n *AVLNode[TKey, TValue]) validate(
+	path string, lo, hi *TKey,
+	parent *AVLNode[TKey, TValue], parentLinks bool,
+	visited map[*AVLNode[TKey, TValue]]struct{},
+) (int, error) {
+	if n == nil {
+		return 0, nil
+	}
+
+	if _, ok := visited[n]; ok {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: "node is reachable more than once",
+		}
+	}
+
+	visited[n] = struct{}{}
+
+	if parentLinks && n.parent != parent {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: "parent link doesn't point to the parent node",
+		}
+	}
+
+	if lo != nil && n.key <= *lo {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("key %v is not greater than %v", n.key, *lo),
+		}
+	}
+
+	if hi != nil && n.key >= *hi {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("key %v is not less than %v", n.key, *hi),
+		}
+	}
+
+	leftHeight, err := n.left.validate(path+".left", lo, &n.key, n, parentLinks, visited)
+
+	if err != nil {
+		return 0, err
+	}
+
+	rightHeight, err := n.right.validate(path+".right", &n.key, hi, n, parentLinks, visited)
+
+	if err != nil {
+		return 0, err
+	}
+
+	height := 1 + maxElem(leftHeight, rightHeight)
+
+	if n.height != height {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("stored height is %d, actual height is %d", n.height, height),
+		}
+	}
+
+	if balance := leftHeight - rightHeight; balance < -1 || balance > 1 {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("balance factor is %d", balance),
+		}
+	}
+
+	if n.hash != nil && n.hash.subtree != n.hash.entry.add(n.left.subtreeHash()).add(n.right.subtreeHash()) {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: "stored subtree hash doesn't match the children",
+		}
+	}
+
+	return height, nil
+}
+
+// Validate checks the structural invariants of the tree:
+// BST ordering of the keys, stored heights, balance factors,
+// parent links and subtree hashes (if enabled), the tree size
+// and absence of nodes reachable more than once. It's meant
+// to be used in tests and debug builds.
+func (t *UnrestrictedAVLTree[TKey, TValue]) Validate() error {
+	visited := map[*UnrestrictedAVLNode[TKey, TValue]]struct{}{}
+	_, err := t.root.validate("root", nil, nil, nil, t.parentLinks, visited)
+
+	if err != nil {
+		return err
+	}
+
+	if len(visited) != t.size {
+		return &ErrorInvalidTree{
+			path:   "root",
+			reason: fmt.Sprintf("tree size is %d, actual number of nodes is %d", t.size, len(visited)),
+		}
+	}
+
+	return nil
+}
+
+func (n *UnrestrictedAVLNode[TKey, TValue]) validate(
+	path string, lo, hi *TKey,
+	parent *UnrestrictedAVLNode[TKey, TValue], parentLinks bool,
+	visited map[*UnrestrictedAVLNode[TKey, TValue]]struct{},
+) (int, error) {
+	if n == nil {
+		return 0, nil
+	}
+
+	if _, ok := visited[n]; ok {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: "node is reachable more than once",
+		}
+	}
+
+	visited[n] = struct{}{}
+
+	if parentLinks && n.parent != parent {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: "parent link doesn't point to the parent node",
+		}
+	}
+
+	if lo != nil && !n.key.Greater(*lo) {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("key %v is not greater than %v", n.key, *lo),
+		}
+	}
+
+	if hi != nil && !n.key.Less(*hi) {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("key %v is not less than %v", n.key, *hi),
+		}
+	}
+
+	leftHeight, err := n.left.validate(path+".left", lo, &n.key, n, parentLinks, visited)
+
+	if err != nil {
+		return 0, err
+	}
+
+	rightHeight, err := n.right.validate(path+".right", &n.key, hi, n, parentLinks, visited)
+
+	if err != nil {
+		return 0, err
+	}
+
+	height := 1 + maxElem(leftHeight, rightHeight)
+
+	if n.height != height {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("stored height is %d, actual height is %d", n.height, height),
+		}
+	}
+
+	if balance := leftHeight - rightHeight; balance < -1 || balance > 1 {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("balance factor is %d", balance),
+		}
+	}
+
+	if n.hash != nil && n.hash.subtree != n.hash.entry.add(n.left.subtreeHash()).add(n.right.subtreeHash()) {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: "stored subtree hash doesn't match the children",
+		}
+	}
+
+	return height, nil
+}
+
+// Validate checks the structural invariants of the tree:
+// BST ordering of the keys, stored heights, balance factors,
+// the tree size, absence of nodes reachable more than once
+// and that the arena only holds the nodes of the tree and
+// the free list. It's meant to be used in tests and debug
+// builds.
+func (t *ArenaAVLTree[TKey, TValue]) Validate() error {
+	visited := map[int32]struct{}{}
+	_, err := t.validate(t.root, "root", nil, nil, visited)
+
+	if err != nil {
+		return err
+	}
+
+	if len(visited) != t.size {
+		return &ErrorInvalidTree{
+			path:   "root",
+			reason: fmt.Sprintf("tree size is %d, actual number of nodes is %d", t.size, len(visited)),
+		}
+	}
+
+	free := 0
+
+	for node := t.free; node != nilIndex; node = t.nodes[node].left {
+		if _, ok := visited[node]; ok || free >= len(t.nodes) {
+			return &ErrorInvalidTree{
+				path:   "free",
+				reason: fmt.Sprintf("node %d is both free and in the tree or in a cycle", node),
+			}
+		}
+
+		free++
+	}
+
+	// the nil index takes
+	// the first slot
+	if used := len(t.nodes) - 1; len(t.nodes) > 0 && used != t.size+free {
+		return &ErrorInvalidTree{
+			path:   "free",
+			reason: fmt.Sprintf("arena holds %d nodes, %d are in the tree and %d are free", used, t.size, free),
+		}
+	}
+
+	return nil
+}
+
+func (t *ArenaAVLTree[TKey, TValue]) validate(
+	node int32, path string, lo, hi *TKey,
+	visited map[int32]struct{},
+) (int32, error) {
+	if node == nilIndex {
+		return 0, nil
+	}
+
+	if node < 0 || int(node) >= len(t.nodes) {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("node index %d is out of the arena", node),
+		}
+	}
+
+	if _, ok := visited[node]; ok {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: "node is reachable more than once",
+		}
+	}
+
+	visited[node] = struct{}{}
+	n := &t.nodes[node]
+
+	if lo != nil && n.key <= *lo {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("key %v is not greater than %v", n.key, *lo),
+		}
+	}
+
+	if hi != nil && n.key >= *hi {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("key %v is not less than %v", n.key, *hi),
+		}
+	}
+
+	leftHeight, err := t.validate(n.left, path+".left", lo, &n.key, visited)
+
+	if err != nil {
+		return 0, err
+	}
+
+	rightHeight, err := t.validate(n.right, path+".right", &n.key, hi, visited)
+
+	if err != nil {
+		return 0, err
+	}
+
+	height := 1 + maxElem(leftHeight, rightHeight)
+
+	if n.height != height {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("stored height is %d, actual height is %d", n.height, height),
+		}
+	}
+
+	if balance := leftHeight - rightHeight; balance < -1 || balance > 1 {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("balance factor is %d", balance),
+		}
+	}
+
+	return height, nil
+}
+
+// Validate checks the structural invariants
+// of the current version of the tree.
+func (t *COWAVLTree[TKey, TValue]) Validate() error {
+	return t.Snapshot().Validate()
+}
+
+// Validate checks the structural invariants of the snapshot:
+// BST ordering of the keys, stored heights, balance factors,
+// the snapshot size and absence of nodes reachable more than
+// once. It's meant to be used in tests and debug builds.
+func (s *COWAVLSnapshot[TKey, TValue]) Validate() error {
+	visited := map[*COWAVLNode[TKey, TValue]]struct{}{}
+	_, err := s.root.validate("root", nil, nil, visited)
+
+	if err != nil {
+		return err
+	}
+
+	if len(visited) != s.size {
+		return &ErrorInvalidTree{
+			path:   "root",
+			reason: fmt.Sprintf("tree size is %d, actual number of nodes is %d", s.size, len(visited)),
+		}
+	}
+
+	return nil
+}
+
+func (n *COWAVLNode[TKey, TValue]) validate(
+	path string, lo, hi *TKey,
+	visited map[*COWAVLNode[TKey, TValue]]struct{},
+) (int, error) {
+	if n == nil {
+		return 0, nil
+	}
+
+	if _, ok := visited[n]; ok {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: "node is reachable more than once",
+		}
+	}
+
+	visited[n] = struct{}{}
+
+	if lo != nil && n.key <= *lo {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("key %v is not greater than %v", n.key, *lo),
+		}
+	}
+
+	if hi != nil && n.key >= *hi {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("key %v is not less than %v", n.key, *hi),
+		}
+	}
+
+	leftHeight, err := n.left.validate(path+".left", lo, &n.key, visited)
+
+	if err != nil {
+		return 0, err
+	}
+
+	rightHeight, err := n.right.validate(path+".right", &n.key, hi, visited)
+
+	if err != nil {
+		return 0, err
+	}
+
+	height := 1 + maxElem(leftHeight, rightHeight)
+
+	if n.height != height {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("stored height is %d, actual height is %d", n.height, height),
+		}
+	}
+
+	if balance := leftHeight - rightHeight; balance < -1 || balance > 1 {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("balance factor is %d", balance),
+		}
+	}
+
+	return height, nil
+}
+
+// Validate checks the structural invariants of the tree:
+// BST ordering of the keys, stored heights, balance factors,
+// parent links, absence of routing nodes which can be unlinked
+// and the tree size. It must only be called when there are no
+// concurrent writers. It's meant to be used in tests and debug
+// builds.
+func (t *ConcurrentAVLTree[TKey, TValue]) Validate() error {
+	holder := &t.holder
+	count := 0
+	_, err := holder.children[dirRight].Load().validate("root", nil, nil, holder, &count)
+
+	if err != nil {
+		return err
+	}
+
+	if size := t.Len(); count != size {
+		return &ErrorInvalidTree{
+			path:   "root",
+			reason: fmt.Sprintf("tree size is %d, actual number of keys is %d", size, count),
+		}
+	}
+
+	return nil
+}
+
+func (n *concurrentNode[TKey, TValue]) validate(
+	path string, lo, hi *TKey,
+	parent *concurrentNode[TKey, TValue],
+	count *int,
+) (int, error) {
+	if n == nil {
+		return 0, nil
+	}
+
+	if n.parent.Load() != parent {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: "parent link doesn't point to the parent node",
+		}
+	}
+
+	if lo != nil && n.key <= *lo {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("key %v is not greater than %v", n.key, *lo),
+		}
+	}
+
+	if hi != nil && n.key >= *hi {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("key %v is not less than %v", n.key, *hi),
+		}
+	}
+
+	left, right := n.children[dirLeft].Load(), n.children[dirRight].Load()
+
+	if n.value.Load() != nil {
+		*count++
+	} else if left == nil || right == nil {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: "routing node has less than two children",
+		}
+	}
+
+	leftHeight, err := left.validate(path+".left", lo, &n.key, n, count)
+
+	if err != nil {
+		return 0, err
+	}
+
+	rightHeight, err := right.validate(path+".right", &n.key, hi, n, count)
+
+	if err != nil {
+		return 0, err
+	}
+
+	height := 1 + maxElem(leftHeight, rightHeight)
+
+	if stored := int(n.height.Load()); stored != height {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("stored height is %d, actual height is %d", stored, height),
+		}
+	}
+
+	if balance := leftHeight - rightHeight; balance < -1 || balance > 1 {
+		return 0, &ErrorInvalidTree{
+			path:   path,
+			reason: fmt.Sprintf("balance factor is %d", balance),
+		}
+	}
+
+	return height, nil
+}
+
+// Validate checks the invariants of all the shards and that
+// each shard only holds the keys within its boundaries and
+// the tree size. It must only be called when there are no
+// concurrent writers. It's meant to be used in tests and
+// debug builds.
+func (t *ShardedAVLTree[TKey, TValue]) Validate() error {
+	t.layout.RLock()
+	defer t.layout.RUnlock()
+
+	total := 0
+
+	for i, shard := range t.shards {
+		path := fmt.Sprintf("shard%d", i)
+		err := shard.tree.Validate()
+
+		if err != nil {
+			return &ErrorInvalidTree{
+				path:   path + "." + err.(*ErrorInvalidTree).path,
+				reason: err.(*ErrorInvalidTree).reason,
+			}
+		}
+
+		err = shard.tree.VisitInOrder(func(node *AVLNode[TKey, TValue]) error {
+			if i > 0 && node.key < t.splits[i-1] || i < len(t.splits) && node.key >= t.splits[i] {
+				return &ErrorInvalidTree{
+					path:   path,
+					reason: fmt.Sprintf("key %v is out of the shard boundaries", node.key),
+				}
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		total += shard.tree.Len()
+	}
+
+	if size := t.Len(); total != size {
+		return &ErrorInvalidTree{
+			path:   "root",
+			reason: fmt.Sprintf("tree size is %d, actual number of keys is %d", size, total),
+		}
+	}
+
+	return nil
+}
+
+// Validate checks the invariants of the tree and that
+// the deadline heap holds exactly the expiring entries
+// in the heap order. It's meant to be used in tests
+// and debug builds.
+func (t *ExpiringAVLTree[TKey, TValue]) Validate() error {
+	err := t.tree.Validate()
+
+	if err != nil {
+		return err
+	}
+
+	expiring := 0
+	err = t.tree.VisitInOrder(func(node *AVLNode[TKey, expiringEntry[TKey, TValue]]) error {
+		item := node.Value.item
+
+		if item == nil {
+			return nil
+		}
+
+		expiring++
+
+		if item.key != node.key || item.index < 0 ||
+			item.index >= len(t.expiries) || t.expiries[item.index] != item {
+			return &ErrorInvalidTree{
+				path:   "root",
+				reason: fmt.Sprintf("deadline of key %v is out of the heap", node.key),
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if expiring != len(t.expiries) {
+		return &ErrorInvalidTree{
+			path:   "root",
+			reason: fmt.Sprintf("heap holds %d deadlines, %d entries expire", len(t.expiries), expiring),
+		}
+	}
+
+	for i := 1; i < len(t.expiries); i++ {
+		if t.expiries.Less(i, (i-1)/2) {
+			return &ErrorInvalidTree{
+				path:   "root",
+				reason: fmt.Sprintf("deadline #%d precedes its heap parent", i),
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package avltree_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zergon321/go-avltree"
+)
+
+func TestValidate(t *testing.T) {
+	tree, err := avltree.NewAVLTree[int, int]()
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		key := rand.Intn(500)
+
+		if rand.Intn(3) == 0 {
+			tree.Remove(key)
+		} else {
+			tree.Add(key, key)
+		}
+
+		assert.Nil(t, tree.Validate())
+	}
+}
+
+func TestValidateCorruptedHeight(t *testing.T) {
+	tree, err := avltree.NewAVLTree[int, int]()
+	assert.Nil(t, err)
+
+	for i := 1; i <= 7; i++ {
+		tree.Add(i, i)
+	}
+
+	tree.Search(1).SetHeight(3)
+	err = tree.Validate()
+
+	var invalid *avltree.ErrorInvalidTree
+	assert.ErrorAs(t, err, &invalid)
+	assert.Equal(t, "root.left.left", invalid.Path())
+}
+
+func TestValidateCycle(t *testing.T) {
+	tree, err := avltree.NewAVLTree[int, int]()
+	assert.Nil(t, err)
+
+	for i := 1; i <= 3; i++ {
+		tree.Add(i, i)
+	}
+
+	node := tree.Search(1)
+	node.SetLeft(tree.Search(2))
+	err = tree.Validate()
+
+	var invalid *avltree.ErrorInvalidTree
+	assert.ErrorAs(t, err, &invalid)
+	assert.Equal(t, "root.left.left", invalid.Path())
+}
+
+func TestValidateUnrestricted(t *testing.T) {
+	tree, err := avltree.NewUnrestrictedAVLTree[Range, struct{}]()
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i += 2 {
+		tree.Add(Range{A: float32(i), B: float32(i + 1)}, struct{}{})
+		assert.Nil(t, tree.Validate())
+	}
+
+	for i := 0; i < 100; i += 4 {
+		tree.Remove(Range{A: float32(i), B: float32(i + 1)})
+		assert.Nil(t, tree.Validate())
+	}
+}
+
+func TestValidateArenaSize(t *testing.T) {
+	tree, err := avltree.NewArenaAVLTree[int, int]()
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		tree.Add(i, i)
+	}
+
+	tree.Remove(3)
+	assert.Equal(t, 9, tree.Len())
+	assert.Nil(t, tree.Validate())
+
+	tree.SetSize(10)
+	err = tree.Validate()
+
+	var invalid *avltree.ErrorInvalidTree
+	assert.ErrorAs(t, err, &invalid)
+}
@@ -0,0 +1,379 @@
+package avltree
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// defaultWatcherBuffer is the number of events
+// the watcher channel holds by default.
+const defaultWatcherBuffer = 64
+
+// EventKind tells how
+// the entry has changed.
+type EventKind int
+
+const (
+	// EventInsert means the key
+	// has been added to the tree.
+	EventInsert EventKind = iota
+	// EventUpdate means the value
+	// of the key has been changed.
+	EventUpdate
+	// EventDelete means the key has
+	// been removed from the tree.
+	EventDelete
+)
+
+// Backpressure tells what the tree does
+// if the watcher channel is full.
+type Backpressure int
+
+const (
+	// BackpressureBlock makes the write wait
+	// until the watcher receives the event.
+	BackpressureBlock Backpressure = iota
+	// BackpressureDropNewest discards
+	// the event which doesn't fit.
+	BackpressureDropNewest
+	// BackpressureDropOldest discards the
+	// oldest event in the channel to make
+	// room for the new one.
+	BackpressureDropOldest
+)
+
+// Event is a change of a watched entry. OldValue is
+// zero for the inserts, NewValue is zero for the deletes.
+// Moving an entry to a different key by Update is seen
+// as the delete of the old key and the insert of the new one.
+type Event[TKey any, TValue any] struct {
+	Kind     EventKind
+	Key      TKey
+	OldValue TValue
+	NewValue TValue
+}
+
+// Watcher receives the events for
+// the keys within [lo, hi] until
+// it's unsubscribed.
+type Watcher[TKey any, TValue any] struct {
+	lo, hi TKey
+
+	// events is nil if the events
+	// are passed to the callback.
+	events       chan Event[TKey, TValue]
+	callback     func(event Event[TKey, TValue])
+	buffer       int
+	backpressure Backpressure
+	dropped      atomic.Uint64
+
+	// mu makes closing the channel
+	// wait for the pending sends.
+	mu     sync.RWMutex
+	closed atomic.Bool
+	done   chan struct{}
+}
+
+// Events returns the channel of the events.
+// It's closed once the watcher is unsubscribed.
+// It's nil if the watcher has a callback.
+func (w *Watcher[TKey, TValue]) Events() <-chan Event[TKey, TValue] {
+	return w.events
+}
+
+// Dropped returns the number of events discarded
+// because the watcher channel has been full.
+func (w *Watcher[TKey, TValue]) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+// Unsubscribe stops delivering the events and
+// unblocks the write waiting for the watcher.
+// It's safe to call it from any goroutine
+// and more than once.
+func (w *Watcher[TKey, TValue]) Unsubscribe() {
+	if !w.closed.CompareAndSwap(false, true) {
+		return
+	}
+
+	close(w.done)
+
+	if w.events != nil {
+		w.mu.Lock()
+		close(w.events)
+		w.mu.Unlock()
+	}
+}
+
+// Passes the event to the watcher
+// according to its backpressure policy
+func (w *Watcher[TKey, TValue]) deliver(event Event[TKey, TValue]) {
+	if w.callback != nil {
+		if !w.closed.Load() {
+			w.callback(event)
+		}
+
+		return
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed.Load() {
+		return
+	}
+
+	switch w.backpressure {
+	case BackpressureBlock:
+		select {
+		case w.events <- event:
+		case <-w.done:
+		}
+
+	case BackpressureDropNewest:
+		select {
+		case w.events <- event:
+		default:
+			w.dropped.Add(1)
+		}
+
+	case BackpressureDropOldest:
+		select {
+		case w.events <- event:
+			return
+		default:
+		}
+
+		select {
+		case <-w.events:
+		default:
+		}
+
+		// the dropped event is either the oldest one
+		// or the new one if the reader has taken
+		// the room made for it
+		w.dropped.Add(1)
+
+		select {
+		case w.events <- event:
+		default:
+		}
+	}
+}
+
+// Creates a watcher with the channel
+func newWatcher[TKey any, TValue any](
+	lo, hi TKey,
+	options []WatcherOption[TKey, TValue],
+) (
+	*Watcher[TKey, TValue], error,
+) {
+	watcher := &Watcher[TKey, TValue]{
+		lo:     lo,
+		hi:     hi,
+		buffer: defaultWatcherBuffer,
+		done:   make(chan struct{}),
+	}
+
+	for i := 0; i < len(options); i++ {
+		option := options[i]
+		err := option(watcher)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	watcher.events = make(chan Event[TKey, TValue], watcher.buffer)
+
+	return watcher, nil
+}
+
+// Watch subscribes to the changes of the keys within [lo, hi].
+// The events are sent to the watcher channel by the writes.
+// If the channel is full, the write acts according to
+// the backpressure policy which is blocking by default:
+// a watcher whose channel isn't read stalls all the writes
+// of its keys until it's unsubscribed.
+func (t *AVLTree[TKey, TValue]) Watch(
+	lo, hi TKey,
+	options ...WatcherOption[TKey, TValue],
+) (
+	*Watcher[TKey, TValue], error,
+) {
+	watcher, err := newWatcher(lo, hi, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t.watchers = append(t.watchers, watcher)
+
+	return watcher, nil
+}
+
+// WatchFunc subscribes to the changes of the keys
+// within [lo, hi]. The callback is called by the write
+// right after the change, it must not write to the tree.
+func (t *AVLTree[TKey, TValue]) WatchFunc(
+	lo, hi TKey,
+	callback func(event Event[TKey, TValue]),
+) *Watcher[TKey, TValue] {
+	watcher := &Watcher[TKey, TValue]{
+		lo:       lo,
+		hi:       hi,
+		callback: callback,
+		done:     make(chan struct{}),
+	}
+	t.watchers = append(t.watchers, watcher)
+
+	return watcher
+}
+
+// Calls the hooks and delivers the event
+// to the watchers of the key
+func (t *AVLTree[TKey, TValue]) notify(kind EventKind, key TKey, oldValue, newValue TValue) {
+	if t.deferring {
+		t.deferred = append(t.deferred, Event[TKey, TValue]{
+			Kind:     kind,
+			Key:      key,
+			OldValue: oldValue,
+			NewValue: newValue,
+		})
+
+		return
+	}
+
+	if t.hooks != nil {
+		t.hooks.changed(kind, key, oldValue, newValue)
+	}
+
+	if len(t.watchers) <= 0 {
+		return
+	}
+
+	t.forgetUnsubscribed()
+	event := Event[TKey, TValue]{
+		Kind:     kind,
+		Key:      key,
+		OldValue: oldValue,
+		NewValue: newValue,
+	}
+
+	// the callbacks may subscribe and unsubscribe,
+	// the new watchers are appended past the end
+	// of the slice and get the next events
+	watchers := t.watchers
+
+	for _, watcher := range watchers {
+		if key >= watcher.lo && key <= watcher.hi {
+			watcher.deliver(event)
+		}
+	}
+}
+
+// Watch subscribes to the changes of the keys within [lo, hi].
+// The events are sent to the watcher channel by the writes.
+// If the channel is full, the write acts according to
+// the backpressure policy which is blocking by default:
+// a watcher whose channel isn't read stalls all the writes
+// of its keys until it's unsubscribed.
+func (t *UnrestrictedAVLTree[TKey, TValue]) Watch(
+	lo, hi TKey,
+	options ...WatcherOption[TKey, TValue],
+) (
+	*Watcher[TKey, TValue], error,
+) {
+	watcher, err := newWatcher(lo, hi, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t.watchers = append(t.watchers, watcher)
+
+	return watcher, nil
+}
+
+// WatchFunc subscribes to the changes of the keys
+// within [lo, hi]. The callback is called by the write
+// right after the change, it must not write to the tree.
+func (t *UnrestrictedAVLTree[TKey, TValue]) WatchFunc(
+	lo, hi TKey,
+	callback func(event Event[TKey, TValue]),
+) *Watcher[TKey, TValue] {
+	watcher := &Watcher[TKey, TValue]{
+		lo:       lo,
+		hi:       hi,
+		callback: callback,
+		done:     make(chan struct{}),
+	}
+	t.watchers = append(t.watchers, watcher)
+
+	return watcher
+}
+
+// Calls the hooks and delivers the event
+// to the watchers of the key
+func (t *UnrestrictedAVLTree[TKey, TValue]) notify(kind EventKind, key TKey, oldValue, newValue TValue) {
+	if t.hooks != nil {
+		t.hooks.changed(kind, key, oldValue, newValue)
+	}
+
+	if len(t.watchers) <= 0 {
+		return
+	}
+
+	t.forgetUnsubscribed()
+	event := Event[TKey, TValue]{
+		Kind:     kind,
+		Key:      key,
+		OldValue: oldValue,
+		NewValue: newValue,
+	}
+
+	// the callbacks may subscribe and unsubscribe,
+	// the new watchers are appended past the end
+	// of the slice and get the next events
+	watchers := t.watchers
+
+	for _, watcher := range watchers {
+		if !key.Less(watcher.lo) && !key.Greater(watcher.hi) {
+			watcher.deliver(event)
+		}
+	}
+}
+
+// Drops the unsubscribed watchers
+func (t *AVLTree[TKey, TValue]) forgetUnsubscribed() {
+	watchers := t.watchers[:0]
+
+	for _, watcher := range t.watchers {
+		if !watcher.closed.Load() {
+			watchers = append(watchers, watcher)
+		}
+	}
+
+	for i := len(watchers); i < len(t.watchers); i++ {
+		t.watchers[i] = nil
+	}
+
+	t.watchers = watchers
+}
+
+// Drops the unsubscribed watchers
+func (t *UnrestrictedAVLTree[TKey, TValue]) forgetUnsubscribed() {
+	watchers := t.watchers[:0]
+
+	for _, watcher := range t.watchers {
+		if !watcher.closed.Load() {
+			watchers = append(watchers, watcher)
+		}
+	}
+
+	for i := len(watchers); i < len(t.watchers); i++ {
+		t.watchers[i] = nil
+	}
+
+	t.watchers = watchers
+}
@@ -0,0 +1,194 @@
+package avltree_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zergon321/go-avltree"
+)
+
+type intEvent = avltree.Event[int, int]
+
+func TestWatchFunc(t *testing.T) {
+	tree, err := avltree.NewAVLTree[int, int]()
+	assert.Nil(t, err)
+
+	events := []intEvent{}
+	watcher := tree.WatchFunc(10, 20, func(event intEvent) {
+		events = append(events, event)
+	})
+
+	tree.Add(5, 5)
+	tree.Add(10, 1)
+	tree.Add(10, 2)
+	err = tree.AddOrUpdate(15, 3, func(oldValue int) (int, error) {
+		return oldValue + 1, nil
+	})
+	assert.Nil(t, err)
+	err = tree.AddOrUpdate(15, 3, func(oldValue int) (int, error) {
+		return oldValue + 1, nil
+	})
+	assert.Nil(t, err)
+	tree.Update(10, 30, 4)
+	tree.Update(5, 20, 5)
+	tree.Remove(15)
+	tree.Remove(25)
+
+	assert.Equal(t, []intEvent{
+		{Kind: avltree.EventInsert, Key: 10, NewValue: 1},
+		{Kind: avltree.EventUpdate, Key: 10, OldValue: 1, NewValue: 2},
+		{Kind: avltree.EventInsert, Key: 15, NewValue: 3},
+		{Kind: avltree.EventUpdate, Key: 15, OldValue: 3, NewValue: 4},
+		{Kind: avltree.EventDelete, Key: 10, OldValue: 2},
+		{Kind: avltree.EventInsert, Key: 20, NewValue: 5},
+		{Kind: avltree.EventDelete, Key: 15, OldValue: 4},
+	}, events)
+
+	events = events[:0]
+
+	for i := 0; i < 40; i++ {
+		tree.Add(i, i)
+	}
+
+	assert.Equal(t, 11, len(events))
+
+	events = events[:0]
+	tree.RemoveRange(0, 12)
+	tree.Clear()
+	assert.Equal(t, 11, len(events))
+
+	for _, event := range events {
+		assert.Equal(t, avltree.EventDelete, event.Kind)
+	}
+
+	events = events[:0]
+	watcher.Unsubscribe()
+	watcher.Unsubscribe()
+	tree.Add(15, 15)
+	assert.Empty(t, events)
+}
+
+func TestWatchFuncSubscribesDuringDelivery(t *testing.T) {
+	tree, err := avltree.NewAVLTree[int, int]()
+	assert.Nil(t, err)
+
+	counts := map[string]int{}
+	var first, second *avltree.Watcher[int, int]
+
+	// the callbacks subscribe and unsubscribe
+	// while the event is being delivered
+	first = tree.WatchFunc(0, 100, func(event intEvent) {
+		counts["first"]++
+		first.Unsubscribe()
+		tree.WatchFunc(0, 100, func(event intEvent) {
+			counts["added"]++
+		})
+	})
+	second = tree.WatchFunc(0, 100, func(event intEvent) {
+		counts["second"]++
+	})
+	tree.WatchFunc(0, 100, func(event intEvent) {
+		counts["third"]++
+		second.Unsubscribe()
+	})
+
+	tree.Add(1, 1)
+	assert.Equal(t, map[string]int{"first": 1, "second": 1, "third": 1}, counts)
+
+	tree.Add(2, 2)
+	assert.Equal(t, map[string]int{"first": 1, "second": 1, "third": 2, "added": 1}, counts)
+}
+
+func TestWatchBackpressure(t *testing.T) {
+	tree, err := avltree.NewAVLTree[int, int]()
+	assert.Nil(t, err)
+
+	newest, err := tree.Watch(0, 100,
+		avltree.WatcherOptionWithBuffer[int, int](2),
+		avltree.WatcherOptionWithBackpressure[int, int](avltree.BackpressureDropNewest))
+	assert.Nil(t, err)
+	oldest, err := tree.Watch(0, 100,
+		avltree.WatcherOptionWithBuffer[int, int](2),
+		avltree.WatcherOptionWithBackpressure[int, int](avltree.BackpressureDropOldest))
+	assert.Nil(t, err)
+
+	for i := 0; i < 5; i++ {
+		tree.Add(i, i)
+	}
+
+	assert.Equal(t, uint64(3), newest.Dropped())
+	assert.Equal(t, uint64(3), oldest.Dropped())
+	assert.Equal(t, 0, (<-newest.Events()).Key)
+	assert.Equal(t, 1, (<-newest.Events()).Key)
+	assert.Equal(t, 3, (<-oldest.Events()).Key)
+	assert.Equal(t, 4, (<-oldest.Events()).Key)
+
+	newest.Unsubscribe()
+	_, ok := <-newest.Events()
+	assert.False(t, ok)
+
+	_, err = tree.Watch(0, 1, avltree.WatcherOptionWithBuffer[int, int](-1))
+	assert.NotNil(t, err)
+
+	_, err = tree.Watch(0, 1, avltree.WatcherOptionWithBackpressure[int, int](42))
+
+	var policyErr *avltree.ErrorUnknownBackpressure
+	assert.ErrorAs(t, err, &policyErr)
+}
+
+func TestWatchBlocking(t *testing.T) {
+	tree, err := avltree.NewAVLTree[int, int]()
+	assert.Nil(t, err)
+
+	watcher, err := tree.Watch(0, 1000, avltree.WatcherOptionWithBuffer[int, int](0))
+	assert.Nil(t, err)
+
+	received := make(chan int)
+
+	go func() {
+		count := 0
+
+		for event := range watcher.Events() {
+			count++
+
+			if event.Key == 499 {
+				watcher.Unsubscribe()
+			}
+		}
+
+		received <- count
+	}()
+
+	// the writes after the unsubscription
+	// must not block
+	for i := 0; i < 1000; i++ {
+		tree.Add(i, i)
+	}
+
+	assert.Equal(t, 500, <-received)
+	assert.Nil(t, tree.Validate())
+}
+
+func TestUnrestrictedWatchFunc(t *testing.T) {
+	tree, err := avltree.NewUnrestrictedAVLTree[IntKey, int]()
+	assert.Nil(t, err)
+
+	events := []avltree.Event[IntKey, int]{}
+	tree.WatchFunc(IntKey(10), IntKey(20), func(event avltree.Event[IntKey, int]) {
+		events = append(events, event)
+	})
+
+	for i := 0; i < 30; i++ {
+		tree.Add(IntKey(i), i)
+	}
+
+	tree.Add(IntKey(12), 0)
+	tree.RemoveIf(func(node *avltree.UnrestrictedAVLNode[IntKey, int]) bool {
+		return node.Key()%2 == 0
+	})
+
+	assert.Equal(t, 11+1+6, len(events))
+	assert.Equal(t, avltree.Event[IntKey, int]{
+		Kind: avltree.EventUpdate, Key: 12, OldValue: 12,
+	}, events[11])
+}